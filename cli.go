@@ -0,0 +1,645 @@
+// cli.go
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// VERSION is replaced when building a release, with -ldflags "-X main.VERSION=1.0.0"
+var VERSION = "dev"
+
+// subcommand is one of the commands of the governor binary.
+type subcommand struct {
+	name    string
+	args    string
+	summary string
+	run     func(args []string) int
+}
+
+func subcommands() []subcommand {
+	return []subcommand{
+		{"render", "", "Write every file once, and run their commands.", renderCommand},
+		{"watch", "[-- command [args...]]", "Keep the files up to date as keys change, optionally running a command.", watchCommand},
+		{"diff", "", "Show what render would change on disk, without writing anything.", diffCommand},
+		{"push", "", "Write the local files back to their keys in Consul.", pushCommand},
+		{"get", "key", "Print the value of a single key.", getCommand},
+		{"validate", "", "Check the config file and Consul settings, without writing anything.", validateCommand},
+		{"version", "", "Print the version of governor.", versionCommand},
+	}
+}
+
+func printUsage(out io.Writer) {
+
+	fmt.Fprintln(out, "Usage: governor <command> [flags]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	for _, sub := range subcommands() {
+		fmt.Fprintf(out, "  %-10s %s\n", sub.name, sub.summary)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Run governor <command> -h to see the flags of a command. Without a command,")
+	fmt.Fprintln(out, "governor takes the flags of earlier versions, such as -watch and -dry-run.")
+}
+
+// RunCLI runs the command given on the command line, and returns its exit code.
+func RunCLI(args []string) int {
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return legacyCommand(args)
+	}
+
+	for _, sub := range subcommands() {
+		if sub.name == args[0] {
+			return sub.run(args[1:])
+		}
+	}
+	if args[0] == "help" {
+		printUsage(os.Stdout)
+		return EXIT_OK
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return EXIT_INVALID_CONFIG
+}
+
+// newFlagSet makes the flags of a subcommand, with usage that describes it.
+func newFlagSet(name string) *flag.FlagSet {
+
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	for _, sub := range subcommands() {
+		if sub.name == name {
+			flagSet.Usage = func() {
+				out := flagSet.Output()
+				fmt.Fprintf(out, "Usage: governor %s [flags] %s\n\n%s\n\nFlags:\n", sub.name, sub.args, sub.summary)
+				flagSet.PrintDefaults()
+			}
+		}
+	}
+	return flagSet
+}
+
+// parseFlags parses the flags of a command. When it should not go on, it
+// returns false along with the exit code to use.
+func parseFlags(flagSet *flag.FlagSet, args []string) (int, bool) {
+
+	switch err := flagSet.Parse(args); err {
+	case nil:
+		return EXIT_OK, true
+	case flag.ErrHelp:
+		return EXIT_OK, false
+	}
+	return EXIT_INVALID_CONFIG, false
+}
+
+// exitWith logs err, if any, and returns the exit code it calls for.
+func exitWith(err error) int {
+	if err != nil {
+		log.Println(err)
+	}
+	return ExitCode(err)
+}
+
+// stringsFlag collects every value of a flag that can be repeated
+type stringsFlag []string
+
+func (values *stringsFlag) String() string {
+	return strings.Join(*values, ",")
+}
+
+func (values *stringsFlag) Set(value string) error {
+	*values = append(*values, value)
+	return nil
+}
+
+// configFlag is -c, which can be repeated. The first value replaces the
+// default, and the rest are added to it.
+type configFlag struct {
+	files []string
+	given bool
+}
+
+func (flag *configFlag) String() string {
+	return strings.Join(flag.files, ", ")
+}
+
+func (flag *configFlag) Set(value string) error {
+	if !flag.given {
+		flag.files = nil
+		flag.given = true
+	}
+	flag.files = append(flag.files, value)
+	return nil
+}
+
+// addConsulFlags defines the flags that override the Consul settings, and
+// returns a function that reads them once the flags are parsed.
+func addConsulFlags(flagSet *flag.FlagSet) func() ConsulConfig {
+
+	flags := ConsulConfig{}
+	flagSet.StringVar(&flags.Address, "consul-addr", "", "Address of Consul, such as https://consul:8501.")
+	flagSet.StringVar(&flags.Scheme, "consul-scheme", "", "Scheme used to reach Consul: http or https.")
+	flagSet.StringVar(&flags.Datacenter, "consul-datacenter", "", "Consul datacenter to read from.")
+	flagSet.StringVar(&flags.Token, "consul-token", "", "Consul ACL token.")
+	flagSet.StringVar(&flags.HttpAuth, "consul-auth", "", "Basic auth for Consul, as user:password.")
+	flagSet.StringVar(&flags.CAFile, "consul-ca-file", "", "CA certificate used to verify Consul.")
+	flagSet.StringVar(&flags.CertFile, "consul-cert-file", "", "Client certificate presented to Consul.")
+	flagSet.StringVar(&flags.KeyFile, "consul-key-file", "", "Key of the client certificate.")
+	flagSet.StringVar(&flags.TLSServerName, "consul-tls-server-name", "", "Server name used to verify Consul.")
+	insecurePtr := flagSet.Bool("consul-insecure-skip-verify", false, "Do not verify the certificate of Consul.")
+	flagSet.IntVar(&flags.RetryAttempts, "retry-attempts", 0, "How many times to try a call while Consul is unavailable (default 5).")
+	flagSet.StringVar(&flags.RetryBackoff, "retry-backoff", "", "How long to wait before the first retry, doubling each time (default 500ms).")
+	flagSet.StringVar(&flags.RetryMaxBackoff, "retry-max-backoff", "", "The longest wait between retries (default 10s).")
+	flagSet.StringVar(&flags.RetryDeadline, "retry-deadline", "", "How long to keep retrying a call (default 1m).")
+
+	return func() ConsulConfig {
+		// Only a flag that was given can override the other settings
+		flagSet.Visit(func(f *flag.Flag) {
+			if f.Name == "consul-insecure-skip-verify" {
+				flags.InsecureSkipVerify = insecurePtr
+			}
+		})
+		return flags
+	}
+}
+
+// connect makes the Consul client of a run from the config file and flags,
+// along with a client that retries reads while Consul is unavailable.
+func connect(configFiles []string, format string, flags ConsulConfig) (*Client, ConsulClient, error) {
+
+	consulConfig, err := LoadConsulConfig(configFiles, format, flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	retryPolicy, err := consulConfig.RetryPolicy()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid Consul settings: %s", err)
+	}
+	apiClient, err := NewClient(consulConfig, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Invalid Consul settings: %s", err)
+	}
+	return apiClient, NewRetryingClient(apiClient, retryPolicy), nil
+}
+
+// commonFlags are shared by every command that reads the config file.
+type commonFlags struct {
+	config *configFlag
+	format *string
+	consul func() ConsulConfig
+}
+
+func addCommonFlags(flagSet *flag.FlagSet) *commonFlags {
+
+	// The format is told from the extension of the file unless it is given
+	format := ""
+	flagSet.Func("format", "Config `format` of the files given with -c: json, yaml, toml or hcl. Told from the extension of the file by default.",
+		func(value string) error {
+			if err := checkConfigFormat(value); err != nil {
+				return err
+			}
+			format = value
+			return nil
+		})
+
+	config := &configFlag{files: []string{"govern.conf"}}
+	flagSet.Var(config, "c", "Config `file`, or directory of config files. Can be repeated.")
+
+	return &commonFlags{
+		config: config,
+		format: &format,
+		consul: addConsulFlags(flagSet),
+	}
+}
+
+// names lists the config files for people to read.
+func (flags *commonFlags) names() string {
+	return flags.config.String()
+}
+
+// connect checks that the config file exists, and makes the Consul clients
+// from it. Errors are logged, and mean the config cannot be used.
+func (flags *commonFlags) connect() (*Client, ConsulClient, bool) {
+
+	if err := checkFileExists(flags.config.files); err != nil {
+		log.Println(err)
+		return nil, nil, false
+	}
+
+	// One client is shared by every key, and retries while Consul is unavailable
+	apiClient, client, err := connect(flags.config.files, *flags.format, flags.consul())
+	if err != nil {
+		log.Println(err)
+		return nil, nil, false
+	}
+
+	log.Println("Using config file: ", flags.names())
+	return apiClient, client, true
+}
+
+func addFailureFlag(flagSet *flag.FlagSet) *string {
+	return flagSet.String("on-failure", string(FAIL_FAST),
+		"What to do when an entry fails: fail-fast, best-effort or all-or-nothing.")
+}
+
+func addLockFlag(flagSet *flag.FlagSet) *string {
+	return flagSet.String("lock", "", "Consul key to lock, so that only the governor holding it writes files.")
+}
+
+// execFlags are the flags of a command run under governor.
+type execFlags struct {
+	onChange    *string
+	signal      *string
+	killTimeout *time.Duration
+	env         func() EnvConfig
+}
+
+func addExecFlags(flagSet *flag.FlagSet) *execFlags {
+
+	flags := &execFlags{
+		onChange: flagSet.String("exec-on-change", ON_CHANGE_SIGNAL,
+			"What to do with the command after -- when a file changes: signal, restart or none."),
+		signal: flagSet.String("exec-signal", "SIGHUP", "Signal sent to the command when a file changes."),
+		killTimeout: flagSet.Duration("exec-kill-timeout", EXEC_KILL_TIMEOUT,
+			"How long the command has to exit when restarted, before it is killed."),
+	}
+
+	// Keys given to the command after -- as environment variables
+	envFlags := EnvConfig{}
+	flagSet.Var((*stringsFlag)(&envFlags.Prefixes), "env-prefix", "Prefix whose keys become environment variables of the command. Can be repeated.")
+	flagSet.Var((*stringsFlag)(&envFlags.Keys), "env-key", "Key, or NAME=key, that becomes an environment variable of the command. Can be repeated.")
+	flagSet.StringVar(&envFlags.NamePrefix, "env-name-prefix", "", "Put in front of the name of every environment variable.")
+	envUpcasePtr := flagSet.Bool("env-upcase", true, "Upper-case the names of environment variables.")
+	envSanitizePtr := flagSet.Bool("env-sanitize", true, "Replace anything but letters, digits and _ in environment variable names with _.")
+	flagSet.BoolVar(&envFlags.Pristine, "env-pristine", false, "Only give the command the variables from Consul, not governor's own environment.")
+
+	flags.env = func() EnvConfig {
+		// Only a flag that was given can override the config file
+		flagSet.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "env-upcase":
+				envFlags.Upcase = envUpcasePtr
+			case "env-sanitize":
+				envFlags.Sanitize = envSanitizePtr
+			}
+		})
+		return envFlags
+	}
+	return flags
+}
+
+// envConfig reads the env section of the config file, with the flags on top.
+func (flags *execFlags) envConfig(configFiles []string, format string, command []string) (EnvConfig, error) {
+
+	envConfig, err := GetEnvConfigFromFiles(configFiles, format)
+	if err != nil {
+		return envConfig, err
+	}
+	envConfig = envConfig.Merge(flags.env())
+	if err := envConfig.Validate(); err != nil {
+		return envConfig, err
+	}
+	if envConfig.Enabled() && len(command) == 0 {
+		return envConfig, fmt.Errorf("Environment variables need a command to run after --")
+	}
+	return envConfig, nil
+}
+
+// stopOnSignal returns a channel that is closed once governor is asked to stop.
+func stopOnSignal() <-chan struct{} {
+
+	stopCh := make(chan struct{})
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signalCh
+		log.Println("Received signal, stopping: ", sig)
+		close(stopCh)
+	}()
+	return stopCh
+}
+
+// render writes every file once, taking turns through the lock if given.
+func render(configFiles []string, format string, apiClient *Client, client ConsulClient, policy FailurePolicy, lockKey string) int {
+
+	if lockKey == "" {
+		return exitWith(Govern(configFiles, format, client, policy))
+	}
+
+	// Only the governor holding the lock writes files
+	locker, err := apiClient.LockKey(lockKey)
+	if err != nil {
+		log.Println("Invalid lock:", err)
+		return EXIT_INVALID_CONFIG
+	}
+	return exitWith(GovernWithLock(configFiles, format, client, locker, policy, stopOnSignal()))
+}
+
+// abortStartup logs an error from writing the files before watching, which
+// only stops governor under best-effort if the config itself is invalid.
+// Watching retries whatever else failed.
+func abortStartup(err error, policy FailurePolicy) bool {
+	if err == nil {
+		return false
+	}
+	log.Println(err)
+	return policy != BEST_EFFORT || ExitCode(err) == EXIT_INVALID_CONFIG
+}
+
+// watch writes every file under the failure policy, and then keeps them up
+// to date until governor is asked to stop.
+func watch(configFiles []string, format string, apiClient *Client, client ConsulClient, policy FailurePolicy, lockKey string) int {
+
+	stopCh := stopOnSignal()
+	if lockKey == "" {
+		if err := Govern(configFiles, format, client, policy); abortStartup(err, policy) {
+			return ExitCode(err)
+		}
+		return exitWith(Watch(configFiles, format, client, stopCh))
+	}
+
+	locker, err := apiClient.LockKey(lockKey)
+	if err != nil {
+		log.Println("Invalid lock:", err)
+		return EXIT_INVALID_CONFIG
+	}
+	if err := GovernWithLock(configFiles, format, client, locker, policy, stopCh); abortStartup(err, policy) {
+		return ExitCode(err)
+	}
+	return exitWith(WatchWithLock(configFiles, format, client, locker, stopCh))
+}
+
+// execute writes the files, and then runs the command under governor.
+func execute(configFiles []string, format string, client ConsulClient, policy FailurePolicy, flags *execFlags, envConfig EnvConfig, command []string) int {
+
+	supervisor, err := NewSupervisor(command, *flags.onChange, *flags.signal, *flags.killTimeout)
+	if err != nil {
+		log.Println(err)
+		return EXIT_INVALID_CONFIG
+	}
+	if err := Govern(configFiles, format, client, policy); abortStartup(err, policy) {
+		return ExitCode(err)
+	}
+	return Exec(configFiles, format, client, supervisor, envConfig)
+}
+
+func renderCommand(args []string) int {
+
+	flagSet := newFlagSet("render")
+	common := addCommonFlags(flagSet)
+	onFailurePtr := addFailureFlag(flagSet)
+	lockKeyPtr := addLockFlag(flagSet)
+	if code, ok := parseFlags(flagSet, args); !ok {
+		return code
+	}
+
+	policy, err := ParseFailurePolicy(*onFailurePtr)
+	if err != nil {
+		log.Println(err)
+		return EXIT_INVALID_CONFIG
+	}
+	apiClient, client, ok := common.connect()
+	if !ok {
+		return EXIT_INVALID_CONFIG
+	}
+	return render(common.config.files, *common.format, apiClient, client, policy, *lockKeyPtr)
+}
+
+func watchCommand(args []string) int {
+
+	flagSet := newFlagSet("watch")
+	common := addCommonFlags(flagSet)
+	onFailurePtr := addFailureFlag(flagSet)
+	lockKeyPtr := addLockFlag(flagSet)
+	exec := addExecFlags(flagSet)
+	if code, ok := parseFlags(flagSet, args); !ok {
+		return code
+	}
+
+	policy, err := ParseFailurePolicy(*onFailurePtr)
+	if err != nil {
+		log.Println(err)
+		return EXIT_INVALID_CONFIG
+	}
+	apiClient, client, ok := common.connect()
+	if !ok {
+		return EXIT_INVALID_CONFIG
+	}
+
+	command := flagSet.Args()
+	envConfig, err := exec.envConfig(common.config.files, *common.format, command)
+	if err != nil {
+		log.Println(err)
+		return EXIT_INVALID_CONFIG
+	}
+
+	// Anything after -- is run under governor once its files are written
+	if len(command) == 0 {
+		return watch(common.config.files, *common.format, apiClient, client, policy, *lockKeyPtr)
+	}
+	if *lockKeyPtr != "" {
+		log.Println("-lock cannot be used with a command to run")
+		return EXIT_INVALID_CONFIG
+	}
+	return execute(common.config.files, *common.format, client, policy, exec, envConfig, command)
+}
+
+func diffCommand(args []string) int {
+
+	flagSet := newFlagSet("diff")
+	common := addCommonFlags(flagSet)
+	onFailurePtr := addFailureFlag(flagSet)
+	if code, ok := parseFlags(flagSet, args); !ok {
+		return code
+	}
+
+	policy, err := ParseFailurePolicy(*onFailurePtr)
+	if err != nil {
+		log.Println(err)
+		return EXIT_INVALID_CONFIG
+	}
+	_, client, ok := common.connect()
+	if !ok {
+		return EXIT_INVALID_CONFIG
+	}
+	return exitWith(DryRun(common.config.files, *common.format, client, policy, os.Stdout))
+}
+
+// pushCommand runs governor push, which writes local files back to Consul.
+func pushCommand(args []string) int {
+
+	flagSet := newFlagSet("push")
+	common := addCommonFlags(flagSet)
+	dryRunPtr := flagSet.Bool("dry-run", false, "Show what would be pushed, without writing to Consul.")
+	yesPtr := flagSet.Bool("yes", false, "Push without asking for confirmation.")
+	forcePtr := flagSet.Bool("force", false, "Overwrite keys even if they were modified since they were read.")
+	if code, ok := parseFlags(flagSet, args); !ok {
+		return code
+	}
+
+	apiClient, client, ok := common.connect()
+	if !ok {
+		return EXIT_INVALID_CONFIG
+	}
+
+	options := PushOptions{DryRun: *dryRunPtr, Force: *forcePtr}
+	if !*yesPtr {
+		options.Confirm = confirmPush(os.Stdin, os.Stderr)
+	}
+	return exitWith(Push(common.config.files, *common.format, client, apiClient, options, os.Stdout))
+}
+
+// getCommand prints a single key. The config file is only read for its
+// Consul settings, and is not needed.
+func getCommand(args []string) int {
+
+	flagSet := newFlagSet("get")
+	common := addCommonFlags(flagSet)
+	decodePtr := flagSet.String("decode", "", "How to decode the value, as the decode field of an entry.")
+	if code, ok := parseFlags(flagSet, args); !ok {
+		return code
+	}
+	if flagSet.NArg() != 1 {
+		flagSet.Usage()
+		return EXIT_INVALID_CONFIG
+	}
+
+	decoder, err := ParseDecoder(*decodePtr)
+	if err != nil {
+		log.Println(err)
+		return EXIT_INVALID_CONFIG
+	}
+
+	// Without -c, fall back to the environment and flags when there is no
+	// config file. A config file that was given has to exist.
+	configFiles := common.config.files
+	if err := checkFileExists(configFiles); err != nil {
+		if common.config.given {
+			log.Println(err)
+			return EXIT_INVALID_CONFIG
+		}
+		configFiles = nil
+	}
+	_, client, err := connect(configFiles, *common.format, common.consul())
+	if err != nil {
+		log.Println(err)
+		return EXIT_INVALID_CONFIG
+	}
+
+	value, err := GetAttribute(flagSet.Arg(0), client)
+	if err != nil {
+		return exitWith(err)
+	}
+	if value, err = decoder(value); err != nil {
+		return exitWith(err)
+	}
+	fmt.Print(value)
+	return EXIT_OK
+}
+
+func validateCommand(args []string) int {
+
+	flagSet := newFlagSet("validate")
+	common := addCommonFlags(flagSet)
+	checkKeysPtr := flagSet.Bool("check-keys", false, "Also look up every key in Consul, and report those that do not exist.")
+	if code, ok := parseFlags(flagSet, args); !ok {
+		return code
+	}
+
+	// Settings that cannot be used are reported along with everything else
+	var client ConsulClient
+	if *checkKeysPtr {
+		if _, consulClient, err := connect(common.config.files, *common.format, common.consul()); err == nil {
+			client = consulClient
+		}
+	}
+
+	validation := ValidateConfig(common.config.files, *common.format, common.consul(), client)
+	for _, warning := range validation.Warnings {
+		fmt.Println("warning:", warning)
+	}
+	for _, err := range validation.Errors {
+		fmt.Println("error:", err)
+	}
+	if !validation.Valid() {
+		fmt.Printf("%s is not valid, with %d error(s)\n", common.names(), len(validation.Errors))
+		return EXIT_INVALID_CONFIG
+	}
+	fmt.Printf("%s is valid, with %d entries\n", common.names(), len(validation.Entries))
+	return EXIT_OK
+}
+
+func versionCommand(args []string) int {
+	fmt.Println("governor", VERSION)
+	return EXIT_OK
+}
+
+// legacyCommand runs governor without a subcommand, taking the flags of
+// earlier versions.
+func legacyCommand(args []string) int {
+
+	flagSet := flag.NewFlagSet("governor", flag.ContinueOnError)
+	flagSet.Usage = func() {
+		printUsage(flagSet.Output())
+		fmt.Fprintln(flagSet.Output(), "\nFlags without a command:")
+		flagSet.PrintDefaults()
+	}
+	common := addCommonFlags(flagSet)
+	watchPtr := flagSet.Bool("watch", false, "Keep watching Consul and rewrite files as keys change.")
+	dryRunPtr := flagSet.Bool("dry-run", false, "Show what would change on disk, without writing anything.")
+	onFailurePtr := addFailureFlag(flagSet)
+	lockKeyPtr := addLockFlag(flagSet)
+	exec := addExecFlags(flagSet)
+	if code, ok := parseFlags(flagSet, args); !ok {
+		return code
+	}
+
+	policy, err := ParseFailurePolicy(*onFailurePtr)
+	if err != nil {
+		log.Println(err)
+		return EXIT_INVALID_CONFIG
+	}
+	apiClient, client, ok := common.connect()
+	if !ok {
+		return EXIT_INVALID_CONFIG
+	}
+
+	command := flagSet.Args()
+	envConfig, err := exec.envConfig(common.config.files, *common.format, command)
+	if err != nil {
+		log.Println(err)
+		return EXIT_INVALID_CONFIG
+	}
+
+	// Anything after -- is run under governor once its files are written
+	if len(command) > 0 {
+		switch {
+		case *dryRunPtr:
+			log.Println("-dry-run cannot be used with a command to run")
+			return EXIT_INVALID_CONFIG
+		case *lockKeyPtr != "":
+			log.Println("-lock cannot be used with a command to run")
+			return EXIT_INVALID_CONFIG
+		}
+		return execute(common.config.files, *common.format, client, policy, exec, envConfig, command)
+	}
+
+	switch {
+	case *dryRunPtr && *watchPtr:
+		log.Println("-dry-run cannot be used with -watch")
+		return EXIT_INVALID_CONFIG
+	case *dryRunPtr:
+		return exitWith(DryRun(common.config.files, *common.format, client, policy, os.Stdout))
+	case *watchPtr:
+		return watch(common.config.files, *common.format, apiClient, client, policy, *lockKeyPtr)
+	}
+	return render(common.config.files, *common.format, apiClient, client, policy, *lockKeyPtr)
+}
@@ -0,0 +1,193 @@
+// cli_test.go
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// captureStdout runs fn, and returns what it printed to stdout.
+func captureStdout(fn func()) string {
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		panic(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+	writer.Close()
+	output, _ := ioutil.ReadAll(reader)
+	return string(output)
+}
+
+func TestRunCLI(t *testing.T) {
+
+	var code int
+	output := captureStdout(func() { code = RunCLI([]string{"version"}) })
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "governor "+VERSION+"\n", output)
+
+	output = captureStdout(func() { code = RunCLI([]string{"help"}) })
+	assert.Equal(t, EXIT_OK, code)
+	for _, sub := range subcommands() {
+		assert.Contains(t, output, "  "+sub.name)
+	}
+
+	assert.Equal(t, EXIT_INVALID_CONFIG, RunCLI([]string{"deploy"}))
+	assert.Equal(t, EXIT_OK, RunCLI([]string{"render", "-h"}))
+	assert.Equal(t, EXIT_INVALID_CONFIG, RunCLI([]string{"render", "-no-such-flag"}))
+	assert.Equal(t, EXIT_INVALID_CONFIG, RunCLI([]string{"render", "-c", "missing.conf"}))
+	assert.Equal(t, EXIT_INVALID_CONFIG, RunCLI([]string{"-c", "missing.conf"}))
+	assert.Equal(t, EXIT_INVALID_CONFIG, RunCLI([]string{"get"}))
+}
+
+func TestGetCommand(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/ssl/keystore" {
+			w.WriteHeader(404)
+			return
+		}
+		value := base64.StdEncoding.EncodeToString([]byte("a2V5c3RvcmU="))
+		w.Header().Set("X-Consul-Index", "10")
+		fmt.Fprintf(w, `[{"Key": "ssl/keystore", "ModifyIndex": 10, "Value": "%s"}]`, value)
+	}))
+	defer server.Close()
+
+	var code int
+	output := captureStdout(func() {
+		code = RunCLI([]string{"get", "-consul-addr", server.URL, "ssl/keystore"})
+	})
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "a2V5c3RvcmU=", output)
+
+	output = captureStdout(func() {
+		code = RunCLI([]string{"get", "-consul-addr", server.URL, "-decode", "base64", "ssl/keystore"})
+	})
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, "keystore", output)
+
+	output = captureStdout(func() {
+		code = RunCLI([]string{"get", "-consul-addr", server.URL, "ssl/missing"})
+	})
+	assert.Equal(t, EXIT_FAILED, code)
+	assert.Equal(t, "", output)
+
+	// A config file that was given has to exist, even with the default name
+	code = RunCLI([]string{"get", "-c", "govern.conf", "-consul-addr", server.URL, "ssl/keystore"})
+	assert.Equal(t, EXIT_INVALID_CONFIG, code)
+}
+
+func TestWatchCommandFailurePolicy(t *testing.T) {
+
+	// Every key is missing
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	}))
+	defer server.Close()
+
+	directory, _ := ioutil.TempDir("", "watch")
+	defer os.RemoveAll(directory)
+
+	stubConfig := filepath.Join(directory, "watch.conf")
+	err := ioutil.WriteFile(stubConfig, []byte(`{"a": "`+directory+`/a.conf", "b": "`+directory+`/b.conf"}`), 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	// The files are written once under the policy before watching
+	code := RunCLI([]string{"watch", "-c", stubConfig, "-consul-addr", server.URL, "-on-failure", "all-or-nothing"})
+	assert.Equal(t, EXIT_NOTHING_WRITTEN, code)
+}
+
+func TestWatchCommandBestEffort(t *testing.T) {
+
+	// Key a exists, and b is missing. A blocking query waits a little.
+	watchingCh := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		blocking := r.URL.Query().Get("index") != ""
+		if blocking {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		if r.URL.Path != "/v1/kv/a" {
+			w.WriteHeader(404)
+			return
+		}
+		if blocking {
+			select {
+			case watchingCh <- struct{}{}:
+			default:
+			}
+		}
+		value := base64.StdEncoding.EncodeToString([]byte("a"))
+		fmt.Fprintf(w, `[{"Key": "a", "ModifyIndex": 1, "Value": "%s"}]`, value)
+	}))
+	defer server.Close()
+
+	directory, _ := ioutil.TempDir("", "watch")
+	defer os.RemoveAll(directory)
+
+	marker := filepath.Join(directory, "marker")
+	stubConfig := filepath.Join(directory, "watch.conf")
+	err := ioutil.WriteFile(stubConfig, []byte(`{
+		"a": {"destination": "`+directory+`/a.conf", "command": "echo run >> `+marker+`"},
+		"b": "`+directory+`/b.conf"
+	}`), 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	// Stop watching once the watcher of a is past its initial pass
+	go func() {
+		<-watchingCh
+		process, _ := os.FindProcess(os.Getpid())
+		process.Signal(syscall.SIGTERM)
+	}()
+
+	code := RunCLI([]string{"watch", "-c", stubConfig, "-consul-addr", server.URL, "-on-failure", "best-effort"})
+	assert.Equal(t, EXIT_OK, code)
+
+	// The command ran when the file was written, and not again when watching
+	contents, _ := ioutil.ReadFile(marker)
+	assert.Equal(t, "run\n", string(contents))
+}
+
+func TestValidateCommand(t *testing.T) {
+
+	directory, _ := ioutil.TempDir("", "validate")
+	defer os.RemoveAll(directory)
+
+	stubConfig := filepath.Join(directory, "validate.conf")
+	err := ioutil.WriteFile(stubConfig, []byte(`{"ssl_key": "`+directory+`/site.key"}`), 0644)
+	if err != nil {
+		panic(err)
+	}
+
+	var code int
+	output := captureStdout(func() { code = RunCLI([]string{"validate", "-c", stubConfig}) })
+	assert.Equal(t, EXIT_OK, code)
+	assert.Equal(t, stubConfig+" is valid, with 1 entries\n", output)
+
+	output = captureStdout(func() {
+		code = RunCLI([]string{"validate", "-c", stubConfig, "-retry-backoff", "soon"})
+	})
+	assert.Equal(t, EXIT_INVALID_CONFIG, code)
+	assert.Equal(t, "error: Invalid Consul settings: Invalid retry_backoff \"soon\", expected a duration such as 5s\n"+stubConfig+" is not valid, with 1 error(s)\n", output)
+
+	output = captureStdout(func() { code = RunCLI([]string{"validate", "-c", "missing.conf"}) })
+	assert.Equal(t, EXIT_INVALID_CONFIG, code)
+	assert.Contains(t, output, "missing.conf is not valid")
+}
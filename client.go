@@ -0,0 +1,63 @@
+// client.go
+package main
+
+import (
+	"github.com/hashicorp/consul/api"
+	"log"
+	"net/http"
+)
+
+// ConsulClient is everything governor asks of Consul. Tests can stand in for
+// Consul by implementing it.
+type ConsulClient interface {
+	Get(key string, options *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, options *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Service(service string, tag string, passingOnly bool, options *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error)
+	Services(options *api.QueryOptions) (map[string][]string, *api.QueryMeta, error)
+}
+
+// Client is the Consul client shared by every key of a run, so that
+// connections, auth and options are only set up once.
+type Client struct {
+	api *api.Client
+}
+
+// NewClient makes a client from the Consul settings. An http client can be
+// given to take control of how requests are made.
+func NewClient(config ConsulConfig, httpClient *http.Client) (*Client, error) {
+
+	// Get client
+	apiConfig, err := config.APIConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// Override the client if we want more control
+	if httpClient != nil {
+		apiConfig.HttpClient = httpClient
+	}
+	log.Println("Set the address to: ", apiConfig.Scheme+"://"+apiConfig.Address)
+
+	// Load the client
+	client, err := api.NewClient(apiConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{api: client}, nil
+}
+
+func (client *Client) Get(key string, options *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	return client.api.KV().Get(key, options)
+}
+
+func (client *Client) List(prefix string, options *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	return client.api.KV().List(prefix, options)
+}
+
+func (client *Client) Service(service string, tag string, passingOnly bool, options *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	return client.api.Health().Service(service, tag, passingOnly, options)
+}
+
+func (client *Client) Services(options *api.QueryOptions) (map[string][]string, *api.QueryMeta, error) {
+	return client.api.Catalog().Services(options)
+}
@@ -0,0 +1,49 @@
+// client_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Both the real and the fake client stand in for Consul
+var _ ConsulClient = &Client{}
+var _ ConsulClient = &fakeConsul{}
+
+func TestNewClient(t *testing.T) {
+
+	client, err := NewClient(ConsulConfig{Address: "consul.example.com:8500"}, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, client)
+
+	_, err = NewClient(ConsulConfig{Scheme: "ftp"}, nil)
+	assert.NotNil(t, err)
+}
+
+func TestGovernSharesClient(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"first":  "one",
+		"second": "two",
+	})
+	defer os.Remove("first.conf")
+	defer os.Remove("second.conf")
+
+	stubConfig := "governor.conf"
+	stubContent := `{"first": "first.conf", "second": "second.conf"}`
+	err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	// Every key goes through the client we were given
+	assert.Nil(t, Govern([]string{stubConfig}, "", consul, FAIL_FAST))
+	assert.Equal(t, 2, consul.Requests())
+
+	contents, err := ioutil.ReadFile("second.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, "two", string(contents))
+}
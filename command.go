@@ -0,0 +1,106 @@
+// command.go
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	COMMAND_TIMEOUT time.Duration = 30 * time.Second
+)
+
+// Timeout returns how long the entry's command may run before being killed.
+func (entry ConfigEntry) Timeout() time.Duration {
+
+	if entry.CommandTimeout == "" {
+		return COMMAND_TIMEOUT
+	}
+
+	timeout, err := time.ParseDuration(entry.CommandTimeout)
+	if err != nil || timeout <= 0 {
+		log.Println("Invalid command timeout, using the default:", entry.CommandTimeout, err)
+		return COMMAND_TIMEOUT
+	}
+	return timeout
+}
+
+// RunCommand runs command through the shell, killing it once timeout passes.
+func RunCommand(command string, timeout time.Duration) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, SHELL[0], append(SHELL[1:], command)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Kill the whole process group on timeout, not only the shell
+	newProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process)
+	}
+
+	log.Println("Running command:", command)
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("Command timed out after %s: %s\n", timeout, command)
+		return ctx.Err()
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		log.Printf("Command exited with code %d: %s\n", exitErr.ExitCode(), command)
+		return err
+	}
+	if err != nil {
+		log.Println("Command could not be run:", command, err)
+		return err
+	}
+
+	log.Println("Command exited with code 0:", command)
+	return nil
+}
+
+// filesChanged reports whether any file of the target was written, and
+// whether any of them changed.
+func (entry ConfigEntry) filesChanged(changed map[string]bool) (bool, bool) {
+
+	written, isChanged := false, false
+	for filePath, fileChanged := range changed {
+		if entry.owns(filePath) {
+			written = true
+			isChanged = isChanged || fileChanged
+		}
+	}
+	return written, isChanged
+}
+
+// RunEntryCommands runs the command of every entry whose file changed on
+// disk, or was written at all for those that always want to run. A command
+// shared by several entries is only run once.
+func RunEntryCommands(configMap map[string]ConfigEntry, changed map[string]bool) {
+
+	done := make(map[string]bool)
+	for name, entry := range configMap {
+		for _, target := range entry.Targets() {
+
+			if target.Command == "" || done[target.Command] {
+				continue
+			}
+
+			written, isChanged := target.filesChanged(changed)
+			if !written {
+				continue
+			}
+			if !target.Always && !isChanged {
+				log.Println("Content is unchanged, not running command for:", name, target.Destination)
+				continue
+			}
+
+			done[target.Command] = true
+			RunCommand(target.Command, target.Timeout())
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// command_test.go
+package main
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRunCommand(t *testing.T) {
+
+	assert.Nil(t, RunCommand("true", time.Second))
+
+	// The exit code should be surfaced as an error
+	err := RunCommand("exit 3", time.Second)
+	exitErr, ok := err.(*exec.ExitError)
+	assert.True(t, ok)
+	assert.Equal(t, 3, exitErr.ExitCode())
+
+	// Commands that run too long are killed
+	start := time.Now()
+	err = RunCommand("sleep 5", 50*time.Millisecond)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestEntryTimeout(t *testing.T) {
+
+	assert.Equal(t, COMMAND_TIMEOUT, ConfigEntry{}.Timeout())
+	assert.Equal(t, 10*time.Second, ConfigEntry{CommandTimeout: "10s"}.Timeout())
+	assert.Equal(t, COMMAND_TIMEOUT, ConfigEntry{CommandTimeout: "soon"}.Timeout())
+}
+
+func TestRunEntryCommands(t *testing.T) {
+
+	// Each command leaves a marker behind so we know it ran
+	always := "always.marker"
+	onChange := "on_change.marker"
+	defer os.Remove(always)
+	defer os.Remove(onChange)
+
+	configMap := map[string]ConfigEntry{
+		"always": {
+			Destination: "always.conf",
+			Command:     "echo run >> " + always,
+			Always:      true,
+		},
+		"on_change": {
+			Destination: "on_change.conf",
+			Command:     "echo run >> " + onChange,
+		},
+		"not_written": {
+			Destination: "not_written.conf",
+			Command:     "echo run >> not_written.marker",
+		},
+	}
+
+	// Nothing changed on disk
+	RunEntryCommands(configMap, map[string]bool{
+		"always.conf":    false,
+		"on_change.conf": false,
+	})
+
+	// Only the second file changed
+	RunEntryCommands(configMap, map[string]bool{
+		"always.conf":    false,
+		"on_change.conf": true,
+	})
+
+	contents, _ := ioutil.ReadFile(always)
+	assert.Equal(t, "run\nrun\n", string(contents))
+
+	contents, _ = ioutil.ReadFile(onChange)
+	assert.Equal(t, "run\n", string(contents))
+
+	_, err := os.Stat("not_written.marker")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,419 @@
+// config.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// The original format, a flat map of Consul key to destination
+	CONFIG_VERSION_FLAT int = 1
+	// A list of entries, each with all of their options
+	CONFIG_VERSION_ENTRIES int = 2
+)
+
+// ConfigEntry describes what to do with a single Consul key. Entries with a
+// template are rendered rather than copied from their key, and tree entries
+// mirror every key under a prefix into a directory.
+type ConfigEntry struct {
+	Name           string  `json:"name"`
+	Key            string  `json:"key"`
+	Destination    string  `json:"destination"`
+	Template       string  `json:"template"`
+	Tree           bool    `json:"tree"`
+	Prune          bool    `json:"prune"`
+	Default        *string `json:"default"`
+	DefaultFile    string  `json:"default_file"`
+	Optional       string  `json:"optional"`
+	Decode         string  `json:"decode"`
+	Command        string  `json:"command"`
+	CommandTimeout string  `json:"command_timeout"`
+	Always         bool    `json:"always"`
+	Mode           string  `json:"mode"`
+	DirMode        string  `json:"dir_mode"`
+	Owner          string  `json:"owner"`
+	Group          string  `json:"group"`
+
+	Destinations []DestinationConfig `json:"destinations"`
+}
+
+// Validate checks that the options of the entry make sense together.
+func (entry ConfigEntry) Validate() error {
+
+	switch {
+	case entry.Destination == "" && len(entry.Destinations) == 0:
+		return fmt.Errorf("An entry needs a destination")
+	case entry.Destination != "" && len(entry.Destinations) != 0:
+		return fmt.Errorf("An entry cannot have both a destination and destinations")
+	case entry.Key == "" && entry.Template == "":
+		return fmt.Errorf("An entry needs either a key or a template")
+	case entry.Key != "" && entry.Template != "":
+		return fmt.Errorf("An entry cannot have both a key and a template")
+	case entry.Tree && entry.Template != "":
+		return fmt.Errorf("A tree entry cannot have a template")
+	case entry.Prune && !entry.Tree:
+		return fmt.Errorf("Only tree entries can be pruned")
+	case (entry.Default != nil || entry.DefaultFile != "") && (entry.Tree || entry.Template != ""):
+		return fmt.Errorf("Only entries of a single key can have a default")
+	case entry.Default != nil && entry.DefaultFile != "":
+		return fmt.Errorf("An entry cannot have both a default and a default_file")
+	case !validOptional(entry.Optional):
+		return fmt.Errorf("Unknown optional %q, expected skip, leave or delete", entry.Optional)
+	case entry.Optional != "" && (entry.Tree || entry.Template != ""):
+		return fmt.Errorf("Only entries of a single key can be optional")
+	case entry.Optional != "" && (entry.Default != nil || entry.DefaultFile != ""):
+		return fmt.Errorf("An optional entry cannot also have a default")
+	case entry.Decode != "" && entry.Template != "":
+		return fmt.Errorf("A template entry cannot be decoded")
+	}
+
+	if _, err := ParseDecoder(entry.Decode); err != nil {
+		return err
+	}
+
+	// Every destination is checked with the options it inherits
+	paths := make(map[string]bool)
+	for _, destination := range entry.Destinations {
+		if destination.Path == "" {
+			return fmt.Errorf("A destination needs a path")
+		}
+		if paths[filepath.Clean(destination.Path)] {
+			return fmt.Errorf("Duplicate destination %q", destination.Path)
+		}
+		paths[filepath.Clean(destination.Path)] = true
+	}
+	for _, target := range entry.Targets() {
+		if err := target.validateTarget(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTarget checks the options that can differ between destinations.
+func (entry ConfigEntry) validateTarget() error {
+
+	if entry.Always && entry.Command == "" {
+		return fmt.Errorf("always needs a command")
+	}
+	if _, err := parseMode(entry.Mode, FILE_MODE); err != nil {
+		return err
+	}
+	if _, err := parseMode(entry.DirMode, FOLDER_MODE); err != nil {
+		return err
+	}
+	if entry.CommandTimeout != "" {
+		if timeout, err := time.ParseDuration(entry.CommandTimeout); err != nil || timeout <= 0 {
+			return fmt.Errorf("Invalid command_timeout %q, expected a duration such as 30s", entry.CommandTimeout)
+		}
+	}
+	return nil
+}
+
+// lineAndColumn finds the position of a byte offset within contents.
+func lineAndColumn(contents []byte, offset int64) (int, int) {
+
+	if offset > int64(len(contents)) {
+		offset = int64(len(contents))
+	}
+	before := contents[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndex(before, []byte("\n"))
+	return line, column
+}
+
+// valueStart skips the separators between the decoder's offset and the
+// start of the next value.
+func valueStart(contents []byte, offset int64) int64 {
+	for offset < int64(len(contents)) && bytes.IndexByte([]byte(" \t\r\n:,"), contents[offset]) != -1 {
+		offset++
+	}
+	return offset
+}
+
+// configSet holds the entries of every config file loaded so far, so that
+// no two files can define the same entry or write the same destination.
+type configSet struct {
+	entries      map[string]ConfigEntry
+	files        map[string]string
+	destinations destinationIndex
+}
+
+func newConfigSet() *configSet {
+	return &configSet{entries: make(map[string]ConfigEntry), files: make(map[string]string)}
+}
+
+// configLoader keeps track of the file being loaded, so errors can point at
+// the line they were found on.
+type configLoader struct {
+	fileName string
+	contents []byte
+	lines    []int
+	set      *configSet
+}
+
+func (loader *configLoader) errorAt(offset int64, err error) error {
+
+	// Errors within a value carry their own offset
+	switch jsonErr := err.(type) {
+	case *json.SyntaxError:
+		offset += jsonErr.Offset - 1
+	case *json.UnmarshalTypeError:
+		offset += jsonErr.Offset - 1
+	}
+
+	line, column := loader.lineAndColumn(offset)
+	return &ConfigError{File: loader.fileName, Line: line, Column: column, Err: err}
+}
+
+func (loader *configLoader) lineAndColumn(offset int64) (int, int) {
+
+	line, column := lineAndColumn(loader.contents, offset)
+
+	// Files converted to JSON only know the line a value came from
+	if loader.lines != nil {
+		if line > len(loader.lines) {
+			line = len(loader.lines)
+		}
+		return loader.lines[line-1], 0
+	}
+	return line, column
+}
+
+// decodeEntry reads a single entry. The flat format also allows an entry to
+// be nothing but its destination.
+func (loader *configLoader) decodeEntry(raw json.RawMessage, offset int64, allowPath bool) (ConfigEntry, error) {
+
+	var destination string
+	if allowPath && json.Unmarshal(raw, &destination) == nil {
+		return ConfigEntry{Destination: destination}, nil
+	}
+
+	var entry ConfigEntry
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&entry); err != nil {
+		return entry, loader.errorAt(offset, err)
+	}
+	return entry, nil
+}
+
+// addEntry validates the entry, and adds it under a name that must be unique
+// across every config file.
+func (loader *configLoader) addEntry(name string, entry ConfigEntry, offset int64) error {
+
+	set := loader.set
+	if err := entry.Validate(); err != nil {
+		return loader.errorAt(offset, err)
+	}
+	if file, ok := set.files[name]; ok {
+		if file != loader.fileName {
+			return loader.errorAt(offset, fmt.Errorf("Duplicate entry %q, also defined in %s", name, file))
+		}
+		return loader.errorAt(offset, fmt.Errorf("Duplicate entry %q", name))
+	}
+	if err := set.destinations.claim(name, loader.fileName, entry); err != nil {
+		return loader.errorAt(offset, err)
+	}
+	set.entries[name] = entry
+	set.files[name] = loader.fileName
+	return nil
+}
+
+// loadFlat reads the original format, where each Consul key maps to its
+// destination, or to an object with its options.
+func (loader *configLoader) loadFlat(versioned bool) error {
+
+	decoder := json.NewDecoder(bytes.NewReader(loader.contents))
+
+	// The opening brace was checked before we got here
+	decoder.Token()
+	for decoder.More() {
+		token, _ := decoder.Token()
+		name := token.(string)
+
+		offset := valueStart(loader.contents, decoder.InputOffset())
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			return loader.errorAt(offset, err)
+		}
+
+		// The version was already checked
+		if versioned && name == "version" {
+			continue
+		}
+
+		entry, err := loader.decodeEntry(raw, offset, true)
+		if err != nil {
+			return err
+		}
+
+		// The name is the key, unless the entry is a template
+		if entry.Key == "" && entry.Template == "" {
+			entry.Key = name
+		}
+		if entry.Name == "" {
+			entry.Name = name
+		}
+
+		if err := loader.addEntry(name, entry, offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadEntries reads the versioned format, which holds a list of entries.
+func (loader *configLoader) loadEntries() error {
+
+	decoder := json.NewDecoder(bytes.NewReader(loader.contents))
+
+	decoder.Token()
+	for decoder.More() {
+		token, _ := decoder.Token()
+		field := token.(string)
+		offset := valueStart(loader.contents, decoder.InputOffset())
+
+		switch field {
+		case "version":
+			var version int
+			if err := decoder.Decode(&version); err != nil {
+				return loader.errorAt(offset, err)
+			}
+
+		case "consul":
+			// Read when the client is made, but checked here
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return loader.errorAt(offset, err)
+			}
+			var consul ConsulConfig
+			consulDecoder := json.NewDecoder(bytes.NewReader(raw))
+			consulDecoder.DisallowUnknownFields()
+			if err := consulDecoder.Decode(&consul); err != nil {
+				return loader.errorAt(offset, err)
+			}
+
+		case "env":
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return loader.errorAt(offset, err)
+			}
+			var env EnvConfig
+			envDecoder := json.NewDecoder(bytes.NewReader(raw))
+			envDecoder.DisallowUnknownFields()
+			if err := envDecoder.Decode(&env); err != nil {
+				return loader.errorAt(offset, err)
+			}
+			if err := env.Validate(); err != nil {
+				return loader.errorAt(offset, err)
+			}
+
+		case "include":
+			// Followed once the file is loaded
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return loader.errorAt(offset, err)
+			}
+
+		case "entries":
+			if token, _ := decoder.Token(); token != json.Delim('[') {
+				return loader.errorAt(offset, fmt.Errorf("The entries must be a list"))
+			}
+
+			for decoder.More() {
+				entryOffset := valueStart(loader.contents, decoder.InputOffset())
+				var raw json.RawMessage
+				if err := decoder.Decode(&raw); err != nil {
+					return loader.errorAt(entryOffset, err)
+				}
+
+				entry, err := loader.decodeEntry(raw, entryOffset, false)
+				if err != nil {
+					return err
+				}
+
+				// Entries are known by their name, their key or their destination
+				name := entry.Name
+				if name == "" {
+					name = entry.Key
+				}
+				if name == "" {
+					name = entry.Destination
+				}
+				if name == "" && len(entry.Destinations) != 0 {
+					name = entry.Destinations[0].Path
+				}
+				entry.Name = name
+
+				if err := loader.addEntry(name, entry, entryOffset); err != nil {
+					return err
+				}
+			}
+			decoder.Token()
+
+		default:
+			return loader.errorAt(offset, fmt.Errorf("Unknown field %q", field))
+		}
+	}
+
+	return nil
+}
+
+// ParseConfig reads either format of governor config. The versioned format
+// is recognised by its numeric version field. Any files it includes are not
+// read, as they are by GetConfigFromFiles.
+func ParseConfig(fileName string, contents []byte) (map[string]ConfigEntry, error) {
+
+	set := newConfigSet()
+	if err := set.parse(fileName, &configSource{contents: contents}); err != nil {
+		return nil, err
+	}
+	return set.entries, nil
+}
+
+// parse adds the entries of a single config file to the set.
+func (set *configSet) parse(fileName string, source *configSource) error {
+
+	contents := source.contents
+	loader := &configLoader{fileName: fileName, contents: contents, lines: source.lines, set: set}
+
+	// Catch syntax errors before anything else
+	var topLevel map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &topLevel); err != nil {
+		return loader.errorAt(0, err)
+	}
+
+	var version int
+	rawVersion, ok := topLevel["version"]
+	if !ok || json.Unmarshal(rawVersion, &version) != nil {
+		return loader.loadFlat(false)
+	}
+
+	switch version {
+	case CONFIG_VERSION_FLAT:
+		return loader.loadFlat(true)
+	case CONFIG_VERSION_ENTRIES:
+		return loader.loadEntries()
+	}
+
+	line, column := loader.lineAndColumn(int64(bytes.Index(contents, rawVersion)))
+	return &ConfigError{File: fileName, Line: line, Column: column,
+		Err: fmt.Errorf("Unsupported config version %d", version)}
+}
+
+// GetConfigFromFiles reads every entry of the config files. Each can be a
+// directory of files, and files can include others.
+func GetConfigFromFiles(configFiles []string, format string) (map[string]ConfigEntry, error) {
+
+	set := newConfigSet()
+	if err := readConfigFiles(configFiles, format, set.parse); err != nil {
+		return nil, err
+	}
+	return set.entries, nil
+}
@@ -0,0 +1,115 @@
+// config_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseVersionedConfig(t *testing.T) {
+
+	config, err := ParseConfig("govern.conf", []byte(`{
+		"version": 2,
+		"entries": [
+			{"key": "nginx/config", "destination": "/etc/nginx/nginx.conf", "command": "nginx -s reload"},
+			{"key": "ssl/key", "destination": "/etc/ssl/site.key", "mode": "0600", "decode": "base64"},
+			{"name": "upstreams", "template": "upstreams.tmpl", "destination": "/etc/nginx/upstreams.conf"},
+			{"key": "motd", "destination": "/etc/motd", "default": ""}
+		]
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(config))
+
+	assert.Equal(t, "/etc/nginx/nginx.conf", config["nginx/config"].Destination)
+	assert.Equal(t, "base64", config["ssl/key"].Decode)
+	assert.Equal(t, "upstreams.tmpl", config["upstreams"].Template)
+	assert.Equal(t, "", config["upstreams"].Key)
+	assert.Equal(t, "", *config["motd"].Default)
+}
+
+func TestParseFlatConfig(t *testing.T) {
+
+	// A key called version is still a key in the flat format
+	config, err := ParseConfig("govern.conf", []byte(`{
+		"version": "/etc/version",
+		"nginx": {"template": "nginx.tmpl", "destination": "/etc/nginx/nginx.conf"}
+	}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "version", config["version"].Key)
+	assert.Equal(t, "", config["nginx"].Key)
+
+	// As is everything else when the version is given explicitly
+	config, err = ParseConfig("govern.conf", []byte(`{"version": 1, "ssl_key": "/path/to/key"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(config))
+	assert.Equal(t, "ssl_key", config["ssl_key"].Key)
+}
+
+func TestParseConfigErrors(t *testing.T) {
+
+	cases := []struct {
+		contents string
+		message  string
+	}{
+		{
+			contents: "{\n  \"a\": \"a.conf\",\n}",
+			message:  "govern.conf:3:1: invalid character '}'",
+		},
+		{
+			contents: "{\n  \"a\": \"a.conf\",\n  \"b\": {\"destination\": \"b.conf\", \"mod\": \"0600\"}\n}",
+			message:  "govern.conf:3:8: json: unknown field \"mod\"",
+		},
+		{
+			contents: "{\n  \"a\": {\"destination\": \"a.conf\", \"mode\": \"rw\"}\n}",
+			message:  "govern.conf:2:8: Invalid mode \"rw\"",
+		},
+		{
+			contents: "{\n  \"a\": {\"destination\": \"a.conf\", \"tree\": \"yes\"}\n}",
+			message:  "govern.conf:2:46: json: cannot unmarshal string",
+		},
+		{
+			contents: "{\"version\": 2, \"entries\": [\n  {\"key\": \"a\", \"destination\": \"a.conf\"},\n  {\"key\": \"a\", \"destination\": \"b.conf\"}\n]}",
+			message:  "govern.conf:3:3: Duplicate entry \"a\"",
+		},
+		{
+			contents: "{\"version\": 2, \"entries\": [\n  {\"key\": \"a\", \"destination\": \"/etc/app.conf\"},\n  {\"key\": \"b\", \"destination\": \"/etc/./app.conf\"}\n]}",
+			message:  "govern.conf:3:3: Destination \"/etc/./app.conf\" is also written by \"a\"",
+		},
+		{
+			contents: "{\n  \"a\": \"/etc/app/a.conf\",\n  \"b\": {\"destination\": \"/etc/app\", \"tree\": true, \"prune\": true}\n}",
+			message:  "govern.conf:3:8: Destination \"/etc/app/a.conf\" of \"a\" would be pruned by this entry",
+		},
+		{
+			contents: "{\"version\": 2, \"entries\": [\n  {\"key\": \"a\"}\n]}",
+			message:  "govern.conf:2:3: An entry needs a destination",
+		},
+		{
+			contents: "{\"version\": 2, \"entries\": [\n  {\"destination\": \"a.conf\"}\n]}",
+			message:  "govern.conf:2:3: An entry needs either a key or a template",
+		},
+		{
+			contents: "{\"version\": 2, \"entries\": [\n  {\"key\": \"a\", \"destination\": \"a.conf\", \"decode\": \"rot13\"}\n]}",
+			message:  "govern.conf:2:3: Unknown decoder \"rot13\"",
+		},
+		{
+			contents: "{\"version\": 2, \"entries\": {}}",
+			message:  "govern.conf:1:27: The entries must be a list",
+		},
+		{
+			contents: "{\"version\": 3, \"entries\": []}",
+			message:  "govern.conf:1:13: Unsupported config version 3",
+		},
+	}
+
+	// A tree that is not pruned leaves other files in its directory alone
+	_, err := ParseConfig("govern.conf", []byte(`{"a": "/etc/app/a.conf", "b": {"destination": "/etc/app", "tree": true}}`))
+	assert.Nil(t, err)
+
+	for _, c := range cases {
+		_, err := ParseConfig("govern.conf", []byte(c.contents))
+		if assert.NotNil(t, err, c.contents) {
+			assert.Contains(t, err.Error(), c.message)
+			assert.Equal(t, EXIT_INVALID_CONFIG, ExitCode(err))
+		}
+	}
+}
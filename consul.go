@@ -0,0 +1,304 @@
+// consul.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CONSUL_DEFAULT_ADDRESS string = "127.0.0.1:8500"
+	CONSUL_DEFAULT_PORT    string = "8500"
+)
+
+// ConsulConfig holds everything needed to reach Consul. Settings are taken
+// from the config file, then the environment, then flags, each overriding
+// the ones before it.
+type ConsulConfig struct {
+	Address            string `json:"address"`
+	Scheme             string `json:"scheme"`
+	Datacenter         string `json:"datacenter"`
+	Token              string `json:"token"`
+	HttpAuth           string `json:"http_auth"`
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	TLSServerName      string `json:"tls_server_name"`
+	InsecureSkipVerify *bool  `json:"insecure_skip_verify"`
+
+	RetryAttempts   int    `json:"retry_attempts"`
+	RetryBackoff    string `json:"retry_backoff"`
+	RetryMaxBackoff string `json:"retry_max_backoff"`
+	RetryDeadline   string `json:"retry_deadline"`
+}
+
+// Merge returns the settings with any that are set in other overriding them.
+func (config ConsulConfig) Merge(other ConsulConfig) ConsulConfig {
+
+	merged := config
+	override := func(value *string, otherValue string) {
+		if otherValue != "" {
+			*value = otherValue
+		}
+	}
+	override(&merged.Address, other.Address)
+	override(&merged.Scheme, other.Scheme)
+	override(&merged.Datacenter, other.Datacenter)
+	override(&merged.Token, other.Token)
+	override(&merged.HttpAuth, other.HttpAuth)
+	override(&merged.CAFile, other.CAFile)
+	override(&merged.CertFile, other.CertFile)
+	override(&merged.KeyFile, other.KeyFile)
+	override(&merged.TLSServerName, other.TLSServerName)
+	if other.InsecureSkipVerify != nil {
+		merged.InsecureSkipVerify = other.InsecureSkipVerify
+	}
+	if other.RetryAttempts != 0 {
+		merged.RetryAttempts = other.RetryAttempts
+	}
+	override(&merged.RetryBackoff, other.RetryBackoff)
+	override(&merged.RetryMaxBackoff, other.RetryMaxBackoff)
+	override(&merged.RetryDeadline, other.RetryDeadline)
+	return merged
+}
+
+// ConsulConfigFromEnv reads the settings from the same environment variables
+// as the consul command, as well as the original CONSUL_HOST and CONSUL_PORT.
+func ConsulConfigFromEnv(getenv func(string) string) (ConsulConfig, error) {
+
+	config := ConsulConfig{
+		Address:       getenv("CONSUL_HTTP_ADDR"),
+		Datacenter:    getenv("CONSUL_DATACENTER"),
+		Token:         getenv("CONSUL_HTTP_TOKEN"),
+		HttpAuth:      getenv("CONSUL_HTTP_AUTH"),
+		CAFile:        getenv("CONSUL_CACERT"),
+		CertFile:      getenv("CONSUL_CLIENT_CERT"),
+		KeyFile:       getenv("CONSUL_CLIENT_KEY"),
+		TLSServerName: getenv("CONSUL_TLS_SERVER_NAME"),
+	}
+
+	// The original variables still win, so existing setups keep working
+	if consulAddress := getenv(CONSUL_ADDRESS); consulAddress != "" {
+		consulPort := getenv(CONSUL_PORT)
+		if consulPort == "" {
+			consulPort = CONSUL_DEFAULT_PORT
+		}
+		config.Address = consulAddress + ":" + consulPort
+	}
+
+	if ssl := getenv("CONSUL_HTTP_SSL"); ssl != "" {
+		enabled, err := strconv.ParseBool(ssl)
+		if err != nil {
+			return config, fmt.Errorf("Invalid CONSUL_HTTP_SSL %q: %s", ssl, err)
+		}
+		if enabled {
+			config.Scheme = "https"
+		}
+	}
+
+	if verify := getenv("CONSUL_HTTP_SSL_VERIFY"); verify != "" {
+		enabled, err := strconv.ParseBool(verify)
+		if err != nil {
+			return config, fmt.Errorf("Invalid CONSUL_HTTP_SSL_VERIFY %q: %s", verify, err)
+		}
+		insecure := !enabled
+		config.InsecureSkipVerify = &insecure
+	}
+
+	return config, nil
+}
+
+// readSection reads a top-level section of the versioned config files into
+// target. Only one file can give each section, and other config files have
+// no sections.
+func readSection(configFiles []string, format string, name string, target interface{}) error {
+
+	found := ""
+	return readConfigFiles(configFiles, format, func(fileName string, source *configSource) error {
+
+		var topLevel map[string]json.RawMessage
+		if err := json.Unmarshal(source.contents, &topLevel); err != nil {
+			return &ConfigError{File: fileName, Err: err}
+		}
+
+		var version int
+		if json.Unmarshal(topLevel["version"], &version) != nil || version != CONFIG_VERSION_ENTRIES {
+			return nil
+		}
+		section, ok := topLevel[name]
+		if !ok {
+			return nil
+		}
+		if found != "" {
+			return &ConfigError{File: fileName, Err: fmt.Errorf("The %s section is already given in %s", name, found)}
+		}
+		found = fileName
+
+		if err := json.Unmarshal(section, target); err != nil {
+			return &ConfigError{File: fileName, Err: err}
+		}
+		return nil
+	})
+}
+
+// GetConsulConfigFromFiles reads the consul settings of the versioned config
+// files.
+func GetConsulConfigFromFiles(configFiles []string, format string) (ConsulConfig, error) {
+
+	var config ConsulConfig
+	err := readSection(configFiles, format, "consul", &config)
+	return config, err
+}
+
+// tlsConfig loads the certificates used to talk to Consul over https.
+func (config ConsulConfig) tlsConfig() (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{ServerName: config.TLSServerName}
+	if config.InsecureSkipVerify != nil {
+		tlsConfig.InsecureSkipVerify = *config.InsecureSkipVerify
+	}
+
+	if config.CAFile != "" {
+		caCert, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("No certificates found in CA file %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, fmt.Errorf("A client certificate needs both a cert file and a key file")
+		}
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
+
+// APIConfig turns the settings into the config of a Consul API client.
+func (config ConsulConfig) APIConfig() (*api.Config, error) {
+
+	apiConfig := &api.Config{
+		Address:    CONSUL_DEFAULT_ADDRESS,
+		Scheme:     "http",
+		Datacenter: config.Datacenter,
+		Token:      config.Token,
+		HttpClient: http.DefaultClient,
+	}
+
+	// The address may carry its own scheme
+	address := config.Address
+	if parts := strings.SplitN(address, "://", 2); len(parts) == 2 {
+		apiConfig.Scheme = parts[0]
+		address = parts[1]
+	}
+	if address != "" {
+		apiConfig.Address = address
+	}
+	if config.Scheme != "" {
+		apiConfig.Scheme = config.Scheme
+	}
+	if apiConfig.Scheme != "http" && apiConfig.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid Consul scheme %q, expected http or https", apiConfig.Scheme)
+	}
+
+	if config.HttpAuth != "" {
+		parts := strings.SplitN(config.HttpAuth, ":", 2)
+		apiConfig.HttpAuth = &api.HttpBasicAuth{Username: parts[0]}
+		if len(parts) == 2 {
+			apiConfig.HttpAuth.Password = parts[1]
+		}
+	}
+
+	// Only build our own transport when there is something to configure
+	usesTLS := config.CAFile != "" || config.CertFile != "" || config.KeyFile != "" ||
+		config.TLSServerName != "" || config.InsecureSkipVerify != nil
+	if usesTLS {
+		tlsConfig, err := config.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		apiConfig.HttpClient = &http.Client{Transport: transport}
+	}
+
+	return apiConfig, nil
+}
+
+// RetryPolicy reads how calls to Consul are retried, starting from the
+// defaults.
+func (config ConsulConfig) RetryPolicy() (RetryPolicy, error) {
+
+	policy := DefaultRetryPolicy
+	if config.RetryAttempts < 0 {
+		return policy, fmt.Errorf("Invalid retry_attempts %d, expected at least 1", config.RetryAttempts)
+	}
+	if config.RetryAttempts > 0 {
+		policy.Attempts = config.RetryAttempts
+	}
+
+	durations := []struct {
+		name   string
+		value  string
+		target *time.Duration
+	}{
+		{"retry_backoff", config.RetryBackoff, &policy.InitialBackoff},
+		{"retry_max_backoff", config.RetryMaxBackoff, &policy.MaxBackoff},
+		{"retry_deadline", config.RetryDeadline, &policy.Deadline},
+	}
+	for _, duration := range durations {
+		if duration.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(duration.value)
+		if err != nil || parsed <= 0 {
+			return policy, fmt.Errorf("Invalid %s %q, expected a duration such as 5s", duration.name, duration.value)
+		}
+		*duration.target = parsed
+	}
+
+	if policy.MaxBackoff < policy.InitialBackoff {
+		return policy, fmt.Errorf("retry_max_backoff cannot be shorter than retry_backoff")
+	}
+	return policy, nil
+}
+
+// LoadConsulConfig combines the config files, the environment and the flags
+// into the settings used to reach Consul.
+func LoadConsulConfig(configFiles []string, format string, flags ConsulConfig) (ConsulConfig, error) {
+
+	// Without config files, only the environment and flags are used
+	fileConfig := ConsulConfig{}
+	if len(configFiles) > 0 {
+		var err error
+		if fileConfig, err = GetConsulConfigFromFiles(configFiles, format); err != nil {
+			return ConsulConfig{}, err
+		}
+	}
+
+	envConfig, err := ConsulConfigFromEnv(os.Getenv)
+	if err != nil {
+		return ConsulConfig{}, err
+	}
+
+	return fileConfig.Merge(envConfig).Merge(flags), nil
+}
@@ -0,0 +1,161 @@
+// consul_test.go
+package main
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestConsulConfigFromEnv(t *testing.T) {
+
+	env := map[string]string{
+		"CONSUL_HTTP_ADDR":       "consul.example.com:8501",
+		"CONSUL_HTTP_TOKEN":      "secret-token",
+		"CONSUL_HTTP_AUTH":       "user:password",
+		"CONSUL_HTTP_SSL":        "true",
+		"CONSUL_HTTP_SSL_VERIFY": "false",
+		"CONSUL_CACERT":          "/etc/consul/ca.pem",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	config, err := ConsulConfigFromEnv(getenv)
+	assert.Nil(t, err)
+	assert.Equal(t, "consul.example.com:8501", config.Address)
+	assert.Equal(t, "https", config.Scheme)
+	assert.Equal(t, "secret-token", config.Token)
+	assert.Equal(t, "user:password", config.HttpAuth)
+	assert.Equal(t, "/etc/consul/ca.pem", config.CAFile)
+	assert.True(t, *config.InsecureSkipVerify)
+
+	// The original variables still win
+	env[CONSUL_ADDRESS] = "localhost"
+	config, err = ConsulConfigFromEnv(getenv)
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:8500", config.Address)
+
+	env["CONSUL_HTTP_SSL"] = "sometimes"
+	_, err = ConsulConfigFromEnv(getenv)
+	assert.NotNil(t, err)
+}
+
+func TestConsulConfigPrecedence(t *testing.T) {
+
+	insecure := true
+	fileConfig := ConsulConfig{Address: "file:8500", Datacenter: "dc1", Token: "file-token"}
+	envConfig := ConsulConfig{Address: "env:8500", Token: "env-token"}
+	flags := ConsulConfig{Token: "flag-token", InsecureSkipVerify: &insecure}
+
+	config := fileConfig.Merge(envConfig).Merge(flags)
+	assert.Equal(t, "env:8500", config.Address)
+	assert.Equal(t, "dc1", config.Datacenter)
+	assert.Equal(t, "flag-token", config.Token)
+	assert.True(t, *config.InsecureSkipVerify)
+}
+
+func TestConsulAPIConfig(t *testing.T) {
+
+	apiConfig, err := ConsulConfig{}.APIConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, CONSUL_DEFAULT_ADDRESS, apiConfig.Address)
+	assert.Equal(t, "http", apiConfig.Scheme)
+	assert.Nil(t, apiConfig.HttpAuth)
+
+	apiConfig, err = ConsulConfig{
+		Address:    "https://consul.example.com:8501",
+		Datacenter: "dc2",
+		Token:      "token",
+		HttpAuth:   "user:password",
+	}.APIConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "consul.example.com:8501", apiConfig.Address)
+	assert.Equal(t, "https", apiConfig.Scheme)
+	assert.Equal(t, "dc2", apiConfig.Datacenter)
+	assert.Equal(t, "token", apiConfig.Token)
+	assert.Equal(t, "user", apiConfig.HttpAuth.Username)
+	assert.Equal(t, "password", apiConfig.HttpAuth.Password)
+
+	_, err = ConsulConfig{Scheme: "ftp"}.APIConfig()
+	assert.NotNil(t, err)
+
+	_, err = ConsulConfig{CertFile: "client.pem"}.APIConfig()
+	assert.NotNil(t, err)
+
+	_, err = ConsulConfig{CAFile: "no-such-ca.pem"}.APIConfig()
+	assert.NotNil(t, err)
+}
+
+func TestConsulConfigFromFile(t *testing.T) {
+
+	stubConfig := "governor.conf"
+	stubContent := `{
+  "version": 2,
+  "consul": {
+    "address": "https://consul.example.com:8501",
+    "token": "file-token"
+  },
+  "entries": [
+    {"key": "nginx", "destination": "nginx.conf"}
+  ]
+}`
+	err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	config, err := GetConsulConfigFromFiles([]string{stubConfig}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "https://consul.example.com:8501", config.Address)
+	assert.Equal(t, "file-token", config.Token)
+
+	// The entries are still read as before
+	configMap, err := GetConfigFromFiles([]string{stubConfig}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "nginx.conf", configMap["nginx"].Destination)
+
+	// Unknown settings are caught when the config is loaded
+	_, err = ParseConfig(stubConfig, []byte(`{"version": 2, "consul": {"adress": "consul"}, "entries": []}`))
+	assert.NotNil(t, err)
+}
+
+func TestConsulOverTLS(t *testing.T) {
+
+	var token string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.URL.Query().Get("token")
+		valueBase64 := base64.StdEncoding.EncodeToString([]byte("over tls"))
+		w.Header().Set("X-Consul-Index", "1")
+		fmt.Fprintf(w, `[{"CreateIndex": 1, "ModifyIndex": 1, "Key": "tls", "Flags": 0, "Value": "%s"}]`, valueBase64)
+	}))
+	defer server.Close()
+
+	// Trust the certificate of the test server
+	caFile := "consul-ca.pem"
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caCert, 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove(caFile)
+
+	client, err := NewClient(ConsulConfig{Address: server.URL, CAFile: caFile, Token: "acl-token"}, nil)
+	assert.Nil(t, err)
+
+	value, err := GetAttribute("tls", client)
+	assert.Nil(t, err)
+	assert.Equal(t, "over tls", value)
+	assert.Equal(t, "acl-token", token)
+
+	// Without the CA the server cannot be trusted
+	client, err = NewClient(ConsulConfig{Address: strings.Replace(server.URL, "https://", "", 1), Scheme: "https"}, nil)
+	assert.Nil(t, err)
+	_, err = GetAttribute("tls", client)
+	assert.NotNil(t, err)
+}
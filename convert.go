@@ -0,0 +1,247 @@
+// convert.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Values that an env file can hold without quotes
+var plainEnvPattern = regexp.MustCompile(`^[A-Za-z0-9_./:@,+-]*$`)
+
+// convertJSON reads a value as JSON and writes it in another format.
+func convertJSON(convert func(interface{}) (string, error)) Decoder {
+	return func(value string) (string, error) {
+		document, err := parseJSON(value)
+		if err != nil {
+			return "", err
+		}
+		return convert(document)
+	}
+}
+
+func sortedKeys(object map[string]interface{}) []string {
+
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// scalarText writes a JSON scalar as plain text, null being empty.
+func scalarText(value interface{}) string {
+
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	case string:
+		return value
+	}
+	return fmt.Sprint(value)
+}
+
+// yamlNode builds the node of a JSON value, with the keys of objects sorted
+// and numbers left as they were written.
+func yamlNode(value interface{}) *yaml.Node {
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, key := range sortedKeys(value) {
+			node.Content = append(node.Content, yamlNode(key), yamlNode(value[key]))
+		}
+		return node
+	case []interface{}:
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range value {
+			node.Content = append(node.Content, yamlNode(item))
+		}
+		return node
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: scalarText(value)}
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: value.String()}
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: value.String()}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: scalarText(value)}
+}
+
+func toYAML(document interface{}) (string, error) {
+
+	var out strings.Builder
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(yamlNode(document)); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// findNull returns the path of the first null in a value, as TOML has none.
+func findNull(path string, value interface{}) string {
+
+	child := func(key string) string {
+		return strings.TrimPrefix(path+"."+key, ".")
+	}
+
+	switch value := value.(type) {
+	case nil:
+		return path
+	case map[string]interface{}:
+		for _, key := range sortedKeys(value) {
+			if found := findNull(child(key), value[key]); found != "" {
+				return found
+			}
+		}
+	case []interface{}:
+		for index, item := range value {
+			if found := findNull(child(strconv.Itoa(index)), item); found != "" {
+				return found
+			}
+		}
+	}
+	return ""
+}
+
+func toTOML(document interface{}) (string, error) {
+
+	table, ok := document.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("Only a JSON object can be converted to TOML")
+	}
+	if path := findNull("", table); path != "" {
+		return "", fmt.Errorf("%s: TOML has no null", path)
+	}
+
+	var out strings.Builder
+	encoder := toml.NewEncoder(&out)
+	encoder.Indent = ""
+	if err := encoder.Encode(table); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// writeINISection writes the values of an object, and its objects as sections
+// named after their path.
+func writeINISection(out *strings.Builder, name string, section map[string]interface{}) error {
+
+	if name != "" {
+		out.WriteString("\n[" + name + "]\n")
+	}
+
+	keys := sortedKeys(section)
+	for _, key := range keys {
+		switch value := section[key].(type) {
+		case map[string]interface{}:
+			continue
+		case []interface{}:
+			return fmt.Errorf("%s: INI has no lists", strings.TrimPrefix(name+"."+key, "."))
+		default:
+			text := scalarText(value)
+			if strings.ContainsAny(text, "\r\n") {
+				return fmt.Errorf("%s: INI values cannot span lines", strings.TrimPrefix(name+"."+key, "."))
+			}
+			out.WriteString(key + " = " + text + "\n")
+		}
+	}
+
+	for _, key := range keys {
+		if child, ok := section[key].(map[string]interface{}); ok {
+			if err := writeINISection(out, strings.TrimPrefix(name+"."+key, "."), child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func toINI(document interface{}) (string, error) {
+
+	section, ok := document.(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("Only a JSON object can be converted to INI")
+	}
+
+	var out strings.Builder
+	if err := writeINISection(&out, "", section); err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(out.String(), "\n"), nil
+}
+
+// flattenEnv names every scalar after its path, such as DATABASE_HOSTS_0.
+func flattenEnv(name string, value interface{}, variables map[string]string) {
+
+	child := func(key string) string {
+		key = strings.ToUpper(unsafeEnvPattern.ReplaceAllString(key, "_"))
+		if name == "" {
+			return key
+		}
+		return name + "_" + key
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			flattenEnv(child(key), item, variables)
+		}
+	case []interface{}:
+		for index, item := range value {
+			flattenEnv(child(strconv.Itoa(index)), item, variables)
+		}
+	default:
+		variables[name] = scalarText(value)
+	}
+}
+
+// envQuote quotes a value the way a shell reads it within double quotes.
+func envQuote(text string) string {
+
+	if plainEnvPattern.MatchString(text) {
+		return text
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`", "\n", `\n`)
+	return `"` + replacer.Replace(text) + `"`
+}
+
+func toEnv(document interface{}) (string, error) {
+
+	if _, ok := document.(map[string]interface{}); !ok {
+		return "", fmt.Errorf("Only a JSON object can be converted to env")
+	}
+
+	variables := make(map[string]string)
+	flattenEnv("", document, variables)
+
+	names := []string{}
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		out.WriteString(name + "=" + envQuote(variables[name]) + "\n")
+	}
+	return out.String(), nil
+}
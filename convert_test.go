@@ -0,0 +1,111 @@
+// convert_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const convertDocument = `{
+  "name": "web",
+  "debug": false,
+  "version": "1.0",
+  "listen": {"port": 8080, "hosts": ["a", "b"]},
+  "upstreams": [{"host": "db1", "weight": 1}, {"host": "db2", "weight": 2}],
+  "motd": "hello: \"world\"\n"
+}`
+
+func convert(t *testing.T, format string, document string) string {
+
+	value, err := ConfigEntry{Decode: format}.DecodeValue(document)
+	assert.Nil(t, err)
+	return value
+}
+
+func TestConvertYAML(t *testing.T) {
+
+	expected := `debug: false
+listen:
+  hosts:
+    - a
+    - b
+  port: 8080
+motd: |
+  hello: "world"
+name: web
+upstreams:
+  - host: db1
+    weight: 1
+  - host: db2
+    weight: 2
+version: "1.0"
+`
+	assert.Equal(t, expected, convert(t, "yaml", convertDocument))
+
+	// Strings that would read as something else are quoted
+	assert.Equal(t, "- \"true\"\n- \"42\"\n- \"\"\n- null\n- {}\n- - nested\n", convert(t, "yaml", `["true", "42", "", null, {}, ["nested"]]`))
+}
+
+func TestConvertTOML(t *testing.T) {
+
+	expected := `debug = false
+motd = "hello: \"world\"\n"
+name = "web"
+version = "1.0"
+
+[listen]
+hosts = ["a", "b"]
+port = 8080
+
+[[upstreams]]
+host = "db1"
+weight = 1
+
+[[upstreams]]
+host = "db2"
+weight = 2
+`
+	assert.Equal(t, expected, convert(t, "toml", convertDocument))
+
+	_, err := ConfigEntry{Decode: "toml"}.DecodeValue(`{"a": {"b": null}}`)
+	assert.EqualError(t, err, "a.b: TOML has no null")
+
+	_, err = ConfigEntry{Decode: "toml"}.DecodeValue(`["a"]`)
+	assert.NotNil(t, err)
+}
+
+func TestConvertINI(t *testing.T) {
+
+	expected := `name = web
+port = 8080
+
+[database]
+host = db1
+
+[database.replica]
+host = db2
+`
+	assert.Equal(t, expected, convert(t, "ini", `{"name": "web", "port": 8080, "database": {"host": "db1", "replica": {"host": "db2"}}}`))
+
+	_, err := ConfigEntry{Decode: "ini"}.DecodeValue(convertDocument)
+	assert.NotNil(t, err)
+}
+
+func TestConvertEnv(t *testing.T) {
+
+	expected := `DEBUG=false
+LISTEN_HOSTS_0=a
+LISTEN_HOSTS_1=b
+LISTEN_PORT=8080
+MOTD="hello: \"world\"\n"
+NAME=web
+UPSTREAMS_0_HOST=db1
+UPSTREAMS_0_WEIGHT=1
+UPSTREAMS_1_HOST=db2
+UPSTREAMS_1_WEIGHT=2
+VERSION=1.0
+`
+	assert.Equal(t, expected, convert(t, "env", convertDocument))
+
+	assert.Equal(t, "API_KEY=\"a \\$b\"\n", convert(t, "env", `{"api-key": "a $b"}`))
+}
@@ -0,0 +1,213 @@
+// decode.go
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Decoder turns the raw value of a key into the content of its file.
+type Decoder func(value string) (string, error)
+
+func decodeBase64(value string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("Value is not valid base64: %s", err)
+	}
+	return string(decoded), nil
+}
+
+func decodeNothing(value string) (string, error) {
+	return value, nil
+}
+
+// parseJSON reads a value as a single JSON document, keeping numbers as written.
+func parseJSON(value string) (interface{}, error) {
+
+	var document interface{}
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("Value is not valid JSON: %s", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Value is not valid JSON: unexpected data after the document")
+	}
+	return document, nil
+}
+
+// encodeJSON writes a value as indented JSON, leaving <, > and & alone.
+func encodeJSON(value interface{}) (string, error) {
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// jsonPathStep is either a field of an object or an index into a list.
+type jsonPathStep struct {
+	field   string
+	index   int
+	isIndex bool
+}
+
+// parseJSONPath reads a path such as database.hosts[0].name, with an optional
+// leading $. Fields that are not plain names can be quoted: servers["eu.west"].
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+
+	steps := []jsonPathStep{}
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("Invalid JSON path %q, missing ]", path)
+			}
+			inside := rest[1:end]
+			if field, err := strconv.Unquote(inside); err == nil {
+				steps = append(steps, jsonPathStep{field: field})
+			} else if index, err := strconv.Atoi(inside); err == nil && index >= 0 {
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			} else {
+				return nil, fmt.Errorf("Invalid JSON path %q, expected an index or a quoted field in []", path)
+			}
+			rest = rest[end+1:]
+			continue
+		}
+
+		rest = strings.TrimPrefix(rest, ".")
+		end := strings.IndexAny(rest, ".[")
+		if end == -1 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("Invalid JSON path %q, empty field", path)
+		}
+		steps = append(steps, jsonPathStep{field: rest[:end]})
+		rest = rest[end:]
+	}
+	return steps, nil
+}
+
+// lookupJSONPath follows the path into a document.
+func lookupJSONPath(document interface{}, steps []jsonPathStep) (interface{}, error) {
+
+	value := document
+	for _, step := range steps {
+		switch current := value.(type) {
+		case map[string]interface{}:
+			child, ok := current[step.field]
+			if step.isIndex || !ok {
+				return nil, fmt.Errorf("JSON value has no field %q", step.field)
+			}
+			value = child
+		case []interface{}:
+			if !step.isIndex {
+				return nil, fmt.Errorf("JSON value has no field %q, it is a list", step.field)
+			}
+			if step.index >= len(current) {
+				return nil, fmt.Errorf("JSON list has no index %d", step.index)
+			}
+			value = current[step.index]
+		default:
+			return nil, fmt.Errorf("JSON value has nothing inside %v", value)
+		}
+	}
+	return value, nil
+}
+
+// decodeJSON extracts the value at path from a JSON document. Strings are
+// written as they are, anything else as JSON.
+func decodeJSON(path string) (Decoder, error) {
+
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(value string) (string, error) {
+		document, err := parseJSON(value)
+		if err != nil {
+			return "", err
+		}
+		found, err := lookupJSONPath(document, steps)
+		if err != nil {
+			return "", err
+		}
+		if text, ok := found.(string); ok {
+			return text, nil
+		}
+		return encodeJSON(found)
+	}, nil
+}
+
+// parseDecoderStep finds the decoder for a single step, such as json:path.
+func parseDecoderStep(step string) (Decoder, error) {
+
+	name, argument := step, ""
+	if parts := strings.SplitN(step, ":", 2); len(parts) == 2 {
+		name, argument = parts[0], parts[1]
+	}
+
+	switch name {
+	case "", "none":
+		return decodeNothing, nil
+	case "base64":
+		return decodeBase64, nil
+	case "json":
+		return decodeJSON(argument)
+	case "yaml":
+		return convertJSON(toYAML), nil
+	case "toml":
+		return convertJSON(toTOML), nil
+	case "ini":
+		return convertJSON(toINI), nil
+	case "env":
+		return convertJSON(toEnv), nil
+	}
+	return nil, fmt.Errorf("Unknown decoder %q", step)
+}
+
+// ParseDecoder finds the decoder an entry asks for. Decoders can be chained
+// with |, such as "base64 | json:database | yaml", and run from left to right.
+func ParseDecoder(name string) (Decoder, error) {
+
+	decoders := []Decoder{}
+	for _, step := range strings.Split(name, "|") {
+		decoder, err := parseDecoderStep(strings.TrimSpace(step))
+		if err != nil {
+			return nil, err
+		}
+		decoders = append(decoders, decoder)
+	}
+
+	return func(value string) (string, error) {
+		var err error
+		for _, decoder := range decoders {
+			if value, err = decoder(value); err != nil {
+				return "", err
+			}
+		}
+		return value, nil
+	}, nil
+}
+
+// DecodeValue applies the entry's decoder to a value fetched from Consul.
+func (entry ConfigEntry) DecodeValue(value string) (string, error) {
+
+	decoder, err := ParseDecoder(entry.Decode)
+	if err != nil {
+		return "", err
+	}
+	return decoder(value)
+}
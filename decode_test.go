@@ -0,0 +1,103 @@
+// decode_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeValue(t *testing.T) {
+
+	value, err := ConfigEntry{}.DecodeValue("plain")
+	assert.Nil(t, err)
+	assert.Equal(t, "plain", value)
+
+	value, err = ConfigEntry{Decode: "base64"}.DecodeValue("a2V5c3RvcmU=")
+	assert.Nil(t, err)
+	assert.Equal(t, "keystore", value)
+
+	_, err = ConfigEntry{Decode: "base64"}.DecodeValue("not base64!")
+	assert.NotNil(t, err)
+}
+
+func TestFetchEntryDefaultAndDecode(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"keystore": "a2V5c3RvcmU=",
+	})
+
+	files, err := FetchEntry(ConfigEntry{Key: "keystore", Destination: "keystore.jks", Decode: "base64"}, consul)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"keystore.jks": "keystore"}, files)
+
+	// A missing key uses its default, as is
+	defaultValue := "workers 4;"
+	files, err = FetchEntry(ConfigEntry{Key: "missing", Destination: "workers.conf", Default: &defaultValue}, consul)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"workers.conf": "workers 4;"}, files)
+
+	_, err = FetchEntry(ConfigEntry{Key: "missing", Destination: "workers.conf"}, consul)
+	assert.NotNil(t, err)
+}
+
+func TestParseJSONPath(t *testing.T) {
+
+	steps, err := parseJSONPath(`$.database.hosts[1]["eu.west"]`)
+	assert.Nil(t, err)
+	assert.Equal(t, []jsonPathStep{
+		{field: "database"}, {field: "hosts"}, {index: 1, isIndex: true}, {field: "eu.west"},
+	}, steps)
+
+	steps, err = parseJSONPath("")
+	assert.Nil(t, err)
+	assert.Empty(t, steps)
+
+	for _, path := range []string{"a..b", "a.", "a[1", "a[-1]", "a[b]"} {
+		_, err = parseJSONPath(path)
+		assert.NotNil(t, err, path)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+
+	document := `{"database": {"password": "hunter2", "port": 5432, "hosts": ["db1", "db2"]}}`
+
+	value, err := ConfigEntry{Decode: "json:database.password"}.DecodeValue(document)
+	assert.Nil(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	value, err = ConfigEntry{Decode: "json:database.hosts[1]"}.DecodeValue(document)
+	assert.Nil(t, err)
+	assert.Equal(t, "db2", value)
+
+	// Anything but a string stays JSON
+	value, err = ConfigEntry{Decode: "json:database.hosts"}.DecodeValue(document)
+	assert.Nil(t, err)
+	assert.Equal(t, "[\n  \"db1\",\n  \"db2\"\n]\n", value)
+
+	value, err = ConfigEntry{Decode: "json:database.port"}.DecodeValue(document)
+	assert.Nil(t, err)
+	assert.Equal(t, "5432\n", value)
+
+	_, err = ConfigEntry{Decode: "json:database.user"}.DecodeValue(document)
+	assert.NotNil(t, err)
+
+	_, err = ConfigEntry{Decode: "json:database"}.DecodeValue("{not json")
+	assert.NotNil(t, err)
+}
+
+func TestDecoderChain(t *testing.T) {
+
+	// {"app": {"name": "web", "workers": 4}}, encoded
+	encoded := "eyJhcHAiOiB7Im5hbWUiOiAid2ViIiwgIndvcmtlcnMiOiA0fX0="
+
+	value, err := ConfigEntry{Decode: "base64 | json:app | yaml"}.DecodeValue(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, "name: web\nworkers: 4\n", value)
+
+	// Every step is checked when the config is loaded
+	_, err = ParseDecoder("base64 | rot13")
+	assert.EqualError(t, err, `Unknown decoder "rot13"`)
+	_, err = ParseDecoder("json:a..b")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,234 @@
+// destinations.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// DestinationConfig is one of several places an entry is written to. Any
+// option that is left out is taken from the entry.
+type DestinationConfig struct {
+	Path           string `json:"path"`
+	Mode           string `json:"mode"`
+	DirMode        string `json:"dir_mode"`
+	Owner          string `json:"owner"`
+	Group          string `json:"group"`
+	Command        string `json:"command"`
+	CommandTimeout string `json:"command_timeout"`
+	Always         *bool  `json:"always"`
+}
+
+func (destination *DestinationConfig) UnmarshalJSON(data []byte) error {
+
+	// The plain form is just the path
+	var path string
+	if err := json.Unmarshal(data, &path); err == nil {
+		*destination = DestinationConfig{Path: path}
+		return nil
+	}
+
+	type plainDestination DestinationConfig
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*plainDestination)(destination))
+}
+
+// Targets returns the entry once for each of its destinations, with the
+// options of that destination.
+func (entry ConfigEntry) Targets() []ConfigEntry {
+
+	if len(entry.Destinations) == 0 {
+		return []ConfigEntry{entry}
+	}
+
+	targets := []ConfigEntry{}
+	for _, destination := range entry.Destinations {
+		target := entry
+		target.Destination = destination.Path
+		target.Destinations = nil
+
+		if destination.Mode != "" {
+			target.Mode = destination.Mode
+		}
+		if destination.DirMode != "" {
+			target.DirMode = destination.DirMode
+		}
+		if destination.Owner != "" {
+			target.Owner = destination.Owner
+		}
+		if destination.Group != "" {
+			target.Group = destination.Group
+		}
+		if destination.Command != "" {
+			target.Command = destination.Command
+		}
+		if destination.CommandTimeout != "" {
+			target.CommandTimeout = destination.CommandTimeout
+		}
+		if destination.Always != nil {
+			target.Always = *destination.Always
+		}
+
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// owns reports whether the file is written by this target. A tree owns every
+// file under its directory.
+func (entry ConfigEntry) owns(filePath string) bool {
+
+	if !entry.Tree {
+		return filepath.Clean(filePath) == filepath.Clean(entry.Destination)
+	}
+	directory := filepath.Clean(entry.Destination) + string(filepath.Separator)
+	return strings.HasPrefix(filepath.Clean(filePath), directory)
+}
+
+// Files places the content of the entry at every one of its destinations.
+func (entry ConfigEntry) Files(content string) map[string]string {
+
+	files := make(map[string]string)
+	for _, target := range entry.Targets() {
+		files[target.Destination] = content
+	}
+	return files
+}
+
+// TreeFiles places every key of a tree under each of the entry's directories.
+func (entry ConfigEntry) TreeFiles(tree map[string]string) map[string]string {
+
+	files := make(map[string]string)
+	for _, target := range entry.Targets() {
+		for filePath, contents := range TreeFiles(target.Destination, tree) {
+			files[filePath] = contents
+		}
+	}
+	return files
+}
+
+// PruneFiles removes the files under every directory of a tree entry that
+// are not in keep, and returns the removed files.
+func (entry ConfigEntry) PruneFiles(keep map[string]string) []string {
+
+	removed := []string{}
+	for _, target := range entry.pruneTargets(keep) {
+		removed = append(removed, PruneTree(target.Destination, keep)...)
+	}
+	return removed
+}
+
+// pruneTargets returns the targets that may be pruned. A prefix without any
+// keys is more likely a mistake than every key having been deleted, so its
+// directory is left alone.
+func (entry ConfigEntry) pruneTargets(keep map[string]string) []ConfigEntry {
+
+	targets := []ConfigEntry{}
+	for _, target := range entry.Targets() {
+		owned := false
+		for filePath := range keep {
+			if target.owns(filePath) {
+				owned = true
+				break
+			}
+		}
+		if !owned {
+			log.Println("Error: prefix", entry.Key, "has no keys, not pruning", target.Destination)
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+// StaleFiles lists the files under every directory of a tree entry that are
+// not in keep.
+func (entry ConfigEntry) StaleFiles(keep map[string]string) []string {
+
+	stale := []string{}
+	for _, target := range entry.pruneTargets(keep) {
+		stale = append(stale, StaleFiles(target.Destination, keep)...)
+	}
+	return stale
+}
+
+// ResolvePermissions resolves the permissions of every target of the entry,
+// in the same order as Targets.
+func (entry ConfigEntry) ResolvePermissions() ([]FilePermissions, error) {
+
+	resolved := []FilePermissions{}
+	for _, target := range entry.Targets() {
+		permissions, err := target.Permissions()
+		if err != nil {
+			return nil, err
+		}
+		warnIfExposed(entry.Name, target, permissions)
+		resolved = append(resolved, permissions)
+	}
+	return resolved, nil
+}
+
+// FilePermissions gives each file the permissions of the target it belongs to.
+func (entry ConfigEntry) FilePermissions(files map[string]string, resolved []FilePermissions) map[string]FilePermissions {
+
+	permissions := make(map[string]FilePermissions)
+	for i, target := range entry.Targets() {
+		for filePath := range files {
+			if target.owns(filePath) {
+				permissions[filePath] = resolved[i]
+			}
+		}
+	}
+	return permissions
+}
+
+// claimedTarget is a destination already written by an entry.
+type claimedTarget struct {
+	name   string
+	file   string
+	target ConfigEntry
+}
+
+// describe names the entry, along with its file when that is not the file
+// being loaded.
+func (claimed claimedTarget) describe(file string) string {
+	if claimed.file == file {
+		return fmt.Sprintf("%q", claimed.name)
+	}
+	return fmt.Sprintf("%q in %s", claimed.name, claimed.file)
+}
+
+// destinationIndex remembers where every entry writes, to catch entries that
+// would overwrite each other's files.
+type destinationIndex struct {
+	claimed []claimedTarget
+}
+
+// claim adds the destinations of an entry of file, unless another entry
+// writes them or a pruned tree would remove them.
+func (index *destinationIndex) claim(name string, file string, entry ConfigEntry) error {
+
+	targets := entry.Targets()
+	for _, target := range targets {
+		for _, other := range index.claimed {
+			switch {
+			case filepath.Clean(target.Destination) == filepath.Clean(other.target.Destination):
+				return fmt.Errorf("Destination %q is also written by %s", target.Destination, other.describe(file))
+			case other.target.Tree && other.target.Prune && other.target.owns(target.Destination):
+				return fmt.Errorf("Destination %q is inside %q, which %s prunes", target.Destination, other.target.Destination, other.describe(file))
+			case target.Tree && target.Prune && target.owns(other.target.Destination):
+				return fmt.Errorf("Destination %q of %s would be pruned by this entry", other.target.Destination, other.describe(file))
+			}
+		}
+	}
+
+	for _, target := range targets {
+		index.claimed = append(index.claimed, claimedTarget{name: name, file: file, target: target})
+	}
+	return nil
+}
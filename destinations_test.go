@@ -0,0 +1,123 @@
+// destinations_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDestinations(t *testing.T) {
+
+	contents := []byte(`{
+  "version": 2,
+  "entries": [
+    {
+      "key": "ssl_cert",
+      "mode": "0644",
+      "destinations": [
+        "/etc/nginx/site.crt",
+        {"path": "/etc/haproxy/site.crt", "mode": "0600", "command": "haproxy reload"}
+      ]
+    }
+  ]
+}`)
+
+	configMap, err := ParseConfig("govern.conf", contents)
+	assert.Nil(t, err)
+
+	entry := configMap["ssl_cert"]
+	assert.Equal(t, []DestinationConfig{
+		{Path: "/etc/nginx/site.crt"},
+		{Path: "/etc/haproxy/site.crt", Mode: "0600", Command: "haproxy reload"},
+	}, entry.Destinations)
+
+	// Each target inherits whatever its destination leaves out
+	targets := entry.Targets()
+	assert.Equal(t, 2, len(targets))
+	assert.Equal(t, "/etc/nginx/site.crt", targets[0].Destination)
+	assert.Equal(t, "0644", targets[0].Mode)
+	assert.Equal(t, "", targets[0].Command)
+	assert.Equal(t, "/etc/haproxy/site.crt", targets[1].Destination)
+	assert.Equal(t, "0600", targets[1].Mode)
+	assert.Equal(t, "haproxy reload", targets[1].Command)
+
+	// An entry without destinations is its own only target
+	single := ConfigEntry{Key: "nginx", Destination: "/etc/nginx/nginx.conf"}
+	assert.Equal(t, []ConfigEntry{single}, single.Targets())
+}
+
+func TestValidateDestinations(t *testing.T) {
+
+	invalid := []ConfigEntry{
+		{Key: "a", Destination: "a.conf", Destinations: []DestinationConfig{{Path: "b.conf"}}},
+		{Key: "a", Destinations: []DestinationConfig{{Path: ""}}},
+		{Key: "a", Destinations: []DestinationConfig{{Path: "a.conf"}, {Path: "./a.conf"}}},
+		{Key: "a", Destinations: []DestinationConfig{{Path: "a.conf", Mode: "rw"}}},
+		{Key: "a", Destinations: []DestinationConfig{{Path: "a.conf", CommandTimeout: "soon", Command: "true"}}},
+	}
+	for _, entry := range invalid {
+		assert.NotNil(t, entry.Validate(), entry.Destinations)
+	}
+
+	always := true
+	valid := ConfigEntry{Key: "a", Destinations: []DestinationConfig{
+		{Path: "a.conf"},
+		{Path: "b.conf", Command: "true", Always: &always},
+	}}
+	assert.Nil(t, valid.Validate())
+}
+
+func TestGovernDestinations(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"ssl_cert": "certificate",
+	})
+
+	directory := "destinations"
+	defer os.RemoveAll(directory)
+
+	stubConfig := "governor.conf"
+	stubContent := `{
+  "version": 2,
+  "entries": [
+    {
+      "key": "ssl_cert",
+      "destinations": [
+        "destinations/nginx/site.crt",
+        {"path": "destinations/haproxy/site.crt", "mode": "0600"},
+        {"path": "destinations/public/site.crt", "mode": "0644"}
+      ]
+    }
+  ]
+}`
+	err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	assert.Nil(t, Govern([]string{stubConfig}, "", consul, FAIL_FAST))
+
+	// The key is fetched once, and written everywhere with its own mode
+	assert.Equal(t, 1, consul.Requests())
+
+	modes := map[string]os.FileMode{
+		"nginx":   FILE_MODE,
+		"haproxy": 0600,
+		"public":  0644,
+	}
+	for folder, mode := range modes {
+		destination := filepath.Join(directory, folder, "site.crt")
+
+		contents, err := ioutil.ReadFile(destination)
+		assert.Nil(t, err)
+		assert.Equal(t, "certificate", string(contents))
+
+		info, err := os.Stat(destination)
+		assert.Nil(t, err)
+		assert.Equal(t, mode, info.Mode().Perm(), destination)
+	}
+}
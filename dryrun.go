@@ -0,0 +1,293 @@
+// dryrun.go
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	STATUS_CREATE    string = "create"
+	STATUS_UPDATE    string = "update"
+	STATUS_UNCHANGED string = "unchanged"
+	STATUS_DELETE    string = "delete"
+
+	// Lines of context around each change of a diff
+	DIFF_CONTEXT int = 3
+	// The most lines of one file times the other that are compared line by line
+	DIFF_MAX_CELLS int = 1 << 20
+	// Shown in place of anything that looks secret
+	MASK string = "********"
+)
+
+// secretLinePattern finds settings such as "password = hunter2"
+var secretLinePattern = regexp.MustCompile(`^(\s*["']?([\w.-]+)["']?\s*[:=]\s*)(\S.*)$`)
+
+type diffLine struct {
+	kind byte
+	text string
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines finds the shortest way from one list of lines to another, using
+// their longest common subsequence. Lines the two have in common at either
+// end are left out of it, and a middle too large to compare line by line is
+// shown as replaced.
+func diffLines(from, to []string) []diffLine {
+
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix &&
+		from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+
+	lines := []diffLine{}
+	for _, text := range from[:prefix] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	lines = append(lines, diffMiddle(from[prefix:len(from)-suffix], to[prefix:len(to)-suffix])...)
+	for _, text := range from[len(from)-suffix:] {
+		lines = append(lines, diffLine{' ', text})
+	}
+	return lines
+}
+
+// diffMiddle compares the lines between the common prefix and suffix.
+func diffMiddle(from, to []string) []diffLine {
+
+	lines := []diffLine{}
+	if len(from)*len(to) > DIFF_MAX_CELLS {
+		for _, text := range from {
+			lines = append(lines, diffLine{'-', text})
+		}
+		for _, text := range to {
+			lines = append(lines, diffLine{'+', text})
+		}
+		return lines
+	}
+
+	common := make([][]int, len(from)+1)
+	for i := range common {
+		common[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			lines = append(lines, diffLine{' ', from[i]})
+			i++
+			j++
+		case j == len(to) || (i < len(from) && common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, diffLine{'-', from[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', to[j]})
+			j++
+		}
+	}
+	return lines
+}
+
+// hunkRange formats the start and length of a hunk in one of the files.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// UnifiedDiff shows the changes from one text to another in the unified
+// format, passing every line through mask first.
+func UnifiedDiff(fromName string, toName string, from string, to string, mask func(string) string) string {
+
+	lines := diffLines(splitLines(from), splitLines(to))
+
+	// Where each line sits in either file
+	fromLine := make([]int, len(lines)+1)
+	toLine := make([]int, len(lines)+1)
+	for i, line := range lines {
+		fromLine[i+1], toLine[i+1] = fromLine[i], toLine[i]
+		if line.kind != '+' {
+			fromLine[i+1]++
+		}
+		if line.kind != '-' {
+			toLine[i+1]++
+		}
+	}
+
+	var diff strings.Builder
+	fmt.Fprintf(&diff, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(lines); {
+
+		// Find the next change
+		for i < len(lines) && lines[i].kind == ' ' {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+
+		// Changes close enough together share a hunk
+		start := i - DIFF_CONTEXT
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(lines) {
+			if lines[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(lines) && lines[next].kind == ' ' {
+				next++
+			}
+			if next == len(lines) || next-end > 2*DIFF_CONTEXT {
+				end += DIFF_CONTEXT
+				if end > len(lines) {
+					end = len(lines)
+				}
+				break
+			}
+			end = next
+		}
+
+		fmt.Fprintf(&diff, "@@ -%s +%s @@\n",
+			hunkRange(fromLine[start], fromLine[end]-fromLine[start]),
+			hunkRange(toLine[start], toLine[end]-toLine[start]))
+		for _, line := range lines[start:end] {
+			fmt.Fprintf(&diff, "%c%s\n", line.kind, mask(line.text))
+		}
+		i = end
+	}
+
+	return diff.String()
+}
+
+// secretMask hides every line of a file that looks secret, and otherwise
+// only the values of settings that look secret.
+func secretMask(name string, filePath string) func(string) string {
+
+	if secretPattern.MatchString(name) || secretPattern.MatchString(filePath) {
+		return func(line string) string {
+			if line == "" {
+				return line
+			}
+			return MASK
+		}
+	}
+
+	return func(line string) string {
+		match := secretLinePattern.FindStringSubmatch(line)
+		if match == nil || !secretPattern.MatchString(match[2]) {
+			return line
+		}
+		return match[1] + MASK
+	}
+}
+
+// DryRun fetches every entry like Govern does, but only reports what it
+// would do to each file instead of writing it.
+func DryRun(configFiles []string, format string, client ConsulClient, policy FailurePolicy, out io.Writer) error {
+
+	// Parse the config file
+	configMap, err := GetConfigFromFiles(configFiles, format)
+	if err != nil {
+		return err
+	}
+
+	plan := fetchEntries(configMap, client, policy)
+	if policy == ALL_OR_NOTHING && len(plan.errs) > 0 {
+		log.Println("Nothing would be written, as not every entry succeeded")
+		return &GovernError{Policy: policy, Errors: plan.errs}
+	}
+
+	filePaths := []string{}
+	for filePath := range plan.files {
+		filePaths = append(filePaths, filePath)
+	}
+	sort.Strings(filePaths)
+
+	for _, filePath := range filePaths {
+		contents := plan.files[filePath]
+		status := fileStatus(filePath, contents, permissionsFor(plan.permissions, filePath))
+		existing := ""
+		if status != STATUS_CREATE {
+			existingContents, _ := ioutil.ReadFile(filePath)
+			existing = string(existingContents)
+		}
+
+		switch {
+		case status == STATUS_UNCHANGED:
+			fmt.Fprintf(out, "%s %s\n", status, filePath)
+			continue
+		case status == STATUS_UPDATE && existing == contents:
+			fmt.Fprintf(out, "%s %s (mode or owner)\n", status, filePath)
+			continue
+		}
+		fmt.Fprintf(out, "%s %s\n", status, filePath)
+
+		fromName := filePath
+		if status == STATUS_CREATE {
+			fromName = os.DevNull
+		}
+		mask := secretMask(plan.owners[filePath], filePath)
+		fmt.Fprint(out, UnifiedDiff(fromName, filePath, existing, contents, mask))
+	}
+
+	// Files that would be removed
+	removals := []string{}
+	for _, entry := range plan.succeeded {
+		if entry.Tree && entry.Prune {
+			removals = append(removals, entry.StaleFiles(plan.files)...)
+		}
+	}
+	for _, entry := range plan.removals {
+		for _, target := range entry.Targets() {
+			if _, err := os.Stat(target.Destination); err == nil {
+				removals = append(removals, target.Destination)
+			}
+		}
+	}
+	sort.Strings(removals)
+	for _, filePath := range removals {
+		fmt.Fprintf(out, "%s %s\n", STATUS_DELETE, filePath)
+	}
+
+	if len(plan.errs) > 0 {
+		return &GovernError{Policy: policy, Errors: plan.errs}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+// env.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// unsafeEnvPattern matches anything that cannot be part of a variable name
+var unsafeEnvPattern = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// EnvConfig maps Consul keys to the environment variables of the command
+// governor runs. Every key under a prefix becomes a variable named after its
+// path below the prefix, and a key can be given as NAME=key to choose its name.
+type EnvConfig struct {
+	Prefixes   []string `json:"prefixes"`
+	Keys       []string `json:"keys"`
+	NamePrefix string   `json:"name_prefix"`
+	Upcase     *bool    `json:"upcase"`
+	Sanitize   *bool    `json:"sanitize"`
+	Pristine   bool     `json:"pristine"`
+}
+
+// Enabled reports whether any key is mapped to the environment.
+func (config EnvConfig) Enabled() bool {
+	return len(config.Prefixes) > 0 || len(config.Keys) > 0
+}
+
+// Merge returns the config with anything set in other overriding it.
+func (config EnvConfig) Merge(other EnvConfig) EnvConfig {
+
+	merged := config
+	if len(other.Prefixes) > 0 {
+		merged.Prefixes = other.Prefixes
+	}
+	if len(other.Keys) > 0 {
+		merged.Keys = other.Keys
+	}
+	if other.NamePrefix != "" {
+		merged.NamePrefix = other.NamePrefix
+	}
+	if other.Upcase != nil {
+		merged.Upcase = other.Upcase
+	}
+	if other.Sanitize != nil {
+		merged.Sanitize = other.Sanitize
+	}
+	merged.Pristine = merged.Pristine || other.Pristine
+	return merged
+}
+
+// splitEnvKey reads a key given as NAME=key, or as just the key.
+func splitEnvKey(key string) (string, string) {
+	if parts := strings.SplitN(key, "=", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", key
+}
+
+func (config EnvConfig) Validate() error {
+
+	for _, prefix := range config.Prefixes {
+		if prefix == "" {
+			return fmt.Errorf("An env prefix cannot be empty")
+		}
+	}
+	for _, key := range config.Keys {
+		if name, consulKey := splitEnvKey(key); consulKey == "" || (name == "" && strings.Contains(key, "=")) {
+			return fmt.Errorf("Invalid env key %q, expected a key or NAME=key", key)
+		}
+	}
+	return nil
+}
+
+// GetEnvConfigFromFiles reads the env settings of the versioned config files.
+func GetEnvConfigFromFiles(configFiles []string, format string) (EnvConfig, error) {
+
+	var config EnvConfig
+	err := readSection(configFiles, format, "env", &config)
+	return config, err
+}
+
+// Name turns the path of a key into the name of its variable. Unless turned
+// off, names are upper-cased and anything a shell would not accept becomes _.
+func (config EnvConfig) Name(path string) string {
+
+	name := config.NamePrefix + strings.Trim(path, "/")
+	if config.Sanitize == nil || *config.Sanitize {
+		name = unsafeEnvPattern.ReplaceAllString(name, "_")
+	}
+	if config.Upcase == nil || *config.Upcase {
+		name = strings.ToUpper(name)
+	}
+	return name
+}
+
+// FetchEnv obtains the value of every variable from Consul.
+func FetchEnv(config EnvConfig, client ConsulClient) (map[string]string, error) {
+
+	values := make(map[string]string)
+	for _, prefix := range config.Prefixes {
+		tree, err := GetTree(prefix, client)
+		if err != nil {
+			return nil, err
+		}
+		for path, value := range tree {
+			values[config.Name(path)] = value
+		}
+	}
+
+	// Keys are named after the last part of their path, unless named explicitly
+	for _, key := range config.Keys {
+		name, consulKey := splitEnvKey(key)
+		value, err := GetAttribute(consulKey, client)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			parts := strings.Split(strings.Trim(consulKey, "/"), "/")
+			name = config.Name(parts[len(parts)-1])
+		}
+		values[name] = value
+	}
+
+	return values, nil
+}
+
+// Environ builds the environment of the command, from governor's own
+// environment unless it should be pristine.
+func (config EnvConfig) Environ(values map[string]string) []string {
+
+	environ := []string{}
+	if !config.Pristine {
+		for _, variable := range os.Environ() {
+			name := strings.SplitN(variable, "=", 2)[0]
+			if _, ok := values[name]; !ok {
+				environ = append(environ, variable)
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		environ = append(environ, name+"="+values[name])
+	}
+	return environ
+}
+
+// envEqual reports whether two sets of variables are the same.
+func envEqual(first map[string]string, second map[string]string) bool {
+
+	if len(first) != len(second) {
+		return false
+	}
+	for name, value := range first {
+		if other, ok := second[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// WatchEnv blocks on every key and prefix of the environment, and calls
+// onChange with the new variables whenever any of them changed.
+func WatchEnv(config EnvConfig, current map[string]string, client ConsulClient, stopCh <-chan struct{}, onChange func(map[string]string)) {
+
+	queries := []func(uint64) (uint64, error){}
+	for _, prefix := range config.Prefixes {
+		prefix := prefix
+		queries = append(queries, func(waitIndex uint64) (uint64, error) {
+			_, lastIndex, err := WatchTree(prefix, waitIndex, client)
+			return lastIndex, err
+		})
+	}
+	for _, key := range config.Keys {
+		_, consulKey := splitEnvKey(key)
+		queries = append(queries, func(waitIndex uint64) (uint64, error) {
+			_, lastIndex, err := WatchAttribute(consulKey, waitIndex, client)
+			return lastIndex, err
+		})
+	}
+
+	// Any moving index means the variables may have changed
+	indexCh := make(chan struct{}, 1)
+	for _, query := range queries {
+		go func(query func(uint64) (uint64, error)) {
+			var waitIndex uint64
+			for {
+				result, ok := runQuery(func() watchResult {
+					lastIndex, err := query(waitIndex)
+					return watchResult{lastIndex: lastIndex, err: err}
+				}, stopCh)
+				if !ok {
+					return
+				}
+				if result.err != nil {
+					log.Println("Error raised when watching the environment, retrying:", result.err)
+					if !waitToRetry(stopCh) {
+						return
+					}
+					continue
+				}
+				if result.lastIndex != waitIndex {
+					waitIndex = result.lastIndex
+					select {
+					case indexCh <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}(query)
+	}
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-indexCh:
+		}
+
+		values, err := FetchEnv(config, client)
+		if err != nil {
+			log.Println("Could not fetch the environment:", err)
+			continue
+		}
+		if envEqual(values, current) {
+			continue
+		}
+
+		log.Println("The environment changed")
+		current = values
+		onChange(values)
+	}
+}
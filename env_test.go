@@ -0,0 +1,134 @@
+// env_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+
+	config := EnvConfig{}
+	assert.Equal(t, "DB_URL", config.Name("db-url"))
+	assert.Equal(t, "NESTED_PORT", config.Name("nested/port"))
+
+	upcase, sanitize := false, false
+	config = EnvConfig{NamePrefix: "app_", Upcase: &upcase}
+	assert.Equal(t, "app_db_url", config.Name("db-url"))
+
+	config = EnvConfig{Upcase: &upcase, Sanitize: &sanitize}
+	assert.Equal(t, "db-url", config.Name("db-url"))
+}
+
+func TestFetchEnv(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"apps/web/db-url":     "postgres://db",
+		"apps/web/cache/port": "6379",
+		"shared/region":       "eu-west-1",
+		"shared/secret":       "hunter2",
+	})
+
+	config := EnvConfig{
+		Prefixes: []string{"apps/web/"},
+		Keys:     []string{"shared/region", "API_SECRET=shared/secret"},
+	}
+	values, err := FetchEnv(config, consul)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_URL":     "postgres://db",
+		"CACHE_PORT": "6379",
+		"REGION":     "eu-west-1",
+		"API_SECRET": "hunter2",
+	}, values)
+
+	// Keys are required, unlike the keys under a prefix
+	_, err = FetchEnv(EnvConfig{Keys: []string{"shared/missing"}}, consul)
+	assert.NotNil(t, err)
+}
+
+func TestEnviron(t *testing.T) {
+
+	os.Setenv("GOVERNOR_TEST_INHERITED", "yes")
+	defer os.Unsetenv("GOVERNOR_TEST_INHERITED")
+
+	values := map[string]string{"B": "2", "A": "1"}
+	environ := EnvConfig{}.Environ(values)
+	assert.Contains(t, environ, "GOVERNOR_TEST_INHERITED=yes")
+	assert.Contains(t, environ, "A=1")
+
+	// Only the variables from Consul, in a stable order
+	assert.Equal(t, []string{"A=1", "B=2"}, EnvConfig{Pristine: true}.Environ(values))
+}
+
+func TestValidateEnv(t *testing.T) {
+
+	assert.Nil(t, EnvConfig{Prefixes: []string{"apps/"}, Keys: []string{"a", "NAME=b"}}.Validate())
+	assert.NotNil(t, EnvConfig{Prefixes: []string{""}}.Validate())
+	assert.NotNil(t, EnvConfig{Keys: []string{"=b"}}.Validate())
+	assert.NotNil(t, EnvConfig{Keys: []string{"NAME="}}.Validate())
+
+	_, err := ParseConfig("govern.conf", []byte(`{"version": 2, "env": {"prefix": "apps/"}, "entries": []}`))
+	assert.NotNil(t, err)
+}
+
+func TestEnvConfigFromFile(t *testing.T) {
+
+	stubConfig := "governor.conf"
+	stubContent := `{
+  "version": 2,
+  "env": {"prefixes": ["apps/web/"], "name_prefix": "WEB_", "pristine": true},
+  "entries": []
+}`
+	err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	config, err := GetEnvConfigFromFiles([]string{stubConfig}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"apps/web/"}, config.Prefixes)
+	assert.Equal(t, "WEB_", config.NamePrefix)
+	assert.True(t, config.Pristine)
+	assert.True(t, config.Enabled())
+
+	// Flags replace what the file says
+	config = config.Merge(EnvConfig{Prefixes: []string{"apps/api/"}})
+	assert.Equal(t, []string{"apps/api/"}, config.Prefixes)
+	assert.Equal(t, "WEB_", config.NamePrefix)
+}
+
+func TestSupervisorRestartsOnEnvChange(t *testing.T) {
+
+	marker := "env.marker"
+	defer os.Remove(marker)
+
+	command := []string{"/bin/sh", "-c",
+		"trap 'exit 0' USR1; echo $GREETING >> " + marker + "; while true; do sleep 0.05; done"}
+	supervisor, err := NewSupervisor(command, ON_CHANGE_NONE, "HUP", time.Second)
+	assert.Nil(t, err)
+	supervisor.SetEnv([]string{"GREETING=hello"})
+
+	signalCh := make(chan os.Signal, 1)
+	envCh := make(chan []string, 1)
+	go func() {
+		waitForLines(t, marker, 1)
+		envCh <- []string{"GREETING=goodbye"}
+
+		waitForLines(t, marker, 2)
+		signalCh <- syscall.SIGUSR1
+	}()
+
+	code, err := supervisor.Run(signalCh, make(chan struct{}), envCh)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, code)
+
+	contents, err := ioutil.ReadFile(marker)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello\ngoodbye\n", string(contents))
+}
@@ -0,0 +1,131 @@
+// errors.go
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FailurePolicy decides what Govern does when an entry fails.
+type FailurePolicy string
+
+const (
+	// Stop at the first failing entry, keeping what was written before it
+	FAIL_FAST FailurePolicy = "fail-fast"
+	// Handle every entry, writing all of those that succeeded
+	BEST_EFFORT FailurePolicy = "best-effort"
+	// Fetch every entry first, and write nothing if any of them failed
+	ALL_OR_NOTHING FailurePolicy = "all-or-nothing"
+)
+
+const (
+	EXIT_OK              int = 0
+	EXIT_FAILED          int = 1
+	EXIT_INVALID_CONFIG  int = 2
+	EXIT_PARTIAL         int = 3
+	EXIT_NOTHING_WRITTEN int = 4
+)
+
+func ParseFailurePolicy(policy string) (FailurePolicy, error) {
+
+	switch FailurePolicy(policy) {
+	case FAIL_FAST, BEST_EFFORT, ALL_OR_NOTHING:
+		return FailurePolicy(policy), nil
+	}
+	return "", fmt.Errorf("Unknown failure policy %q, expected one of %s, %s or %s",
+		policy, FAIL_FAST, BEST_EFFORT, ALL_OR_NOTHING)
+}
+
+// KeyNotFoundError is returned when a key does not exist in Consul.
+type KeyNotFoundError struct {
+	Key string
+}
+
+func (err *KeyNotFoundError) Error() string {
+	return "Key supplied returned a nil value - does it exist: " + err.Key
+}
+
+// ConfigError is returned when the governor config cannot be used.
+// The line and column are zero when the error is not about a position.
+type ConfigError struct {
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+func (err *ConfigError) Error() string {
+	if err.Line > 0 && err.Column > 0 {
+		return fmt.Sprintf("Invalid config file %s:%d:%d: %s", err.File, err.Line, err.Column, err.Err)
+	}
+	if err.Line > 0 {
+		return fmt.Sprintf("Invalid config file %s:%d: %s", err.File, err.Line, err.Err)
+	}
+	return fmt.Sprintf("Invalid config file %s: %s", err.File, err.Err)
+}
+
+// EntryError is returned when a single entry of the config fails.
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (err *EntryError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Name, err.Err)
+}
+
+// WriteError collects every file that could not be written.
+type WriteError struct {
+	Errors []error
+}
+
+func (err *WriteError) Error() string {
+
+	messages := []string{}
+	for _, fileErr := range err.Errors {
+		messages = append(messages, fileErr.Error())
+	}
+	return fmt.Sprintf("%d file(s) could not be written: %s",
+		len(err.Errors), strings.Join(messages, "; "))
+}
+
+// GovernError collects every entry that failed during a run.
+type GovernError struct {
+	Policy FailurePolicy
+	Errors []error
+}
+
+func (err *GovernError) Error() string {
+
+	messages := []string{}
+	for _, entryErr := range err.Errors {
+		messages = append(messages, entryErr.Error())
+	}
+	return fmt.Sprintf("%d failure(s) with policy %s: %s",
+		len(err.Errors), err.Policy, strings.Join(messages, "; "))
+}
+
+func (err *GovernError) ExitCode() int {
+
+	switch err.Policy {
+	case BEST_EFFORT:
+		return EXIT_PARTIAL
+	case ALL_OR_NOTHING:
+		return EXIT_NOTHING_WRITTEN
+	}
+	return EXIT_FAILED
+}
+
+// ExitCode returns the exit code the binary should use for err.
+func ExitCode(err error) int {
+
+	switch err := err.(type) {
+	case nil:
+		return EXIT_OK
+	case *ConfigError:
+		return EXIT_INVALID_CONFIG
+	case *GovernError:
+		return err.ExitCode()
+	}
+	return EXIT_FAILED
+}
@@ -0,0 +1,113 @@
+// errors_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseFailurePolicy(t *testing.T) {
+
+	policy, err := ParseFailurePolicy("best-effort")
+	assert.Nil(t, err)
+	assert.Equal(t, BEST_EFFORT, policy)
+
+	_, err = ParseFailurePolicy("sometimes")
+	assert.NotNil(t, err)
+}
+
+func TestMissingKeyError(t *testing.T) {
+
+	consul := stubConsul(map[string]string{})
+
+	_, err := GetAttribute("missing", consul)
+	keyErr, ok := err.(*KeyNotFoundError)
+	assert.True(t, ok)
+	assert.Equal(t, "missing", keyErr.Key)
+}
+
+func TestConfigErrors(t *testing.T) {
+
+	_, err := GetConfigFromFiles([]string{"does_not_exist.conf"}, "")
+	assert.Equal(t, EXIT_INVALID_CONFIG, ExitCode(err))
+
+	// A syntax error is no longer silently ignored
+	stubConfig := "invalid.conf"
+	err = ioutil.WriteFile(stubConfig, []byte(`{"a": "a.conf",}`), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	_, err = GetConfigFromFiles([]string{stubConfig}, "")
+	assert.Equal(t, EXIT_INVALID_CONFIG, ExitCode(err))
+
+	assert.Equal(t, EXIT_INVALID_CONFIG, ExitCode(Govern([]string{stubConfig}, "", nil, FAIL_FAST)))
+}
+
+func TestFailurePolicies(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"a": "first",
+		"c": "third",
+	})
+
+	// The entry in the middle does not exist
+	stubConfig := "governor.conf"
+	stubContent := `{"a": "a.conf", "b": "b.conf", "c": "c.conf"}`
+	err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	written := func() []string {
+		files := []string{}
+		for _, fileName := range []string{"a.conf", "b.conf", "c.conf"} {
+			if _, err := os.Stat(fileName); err == nil {
+				files = append(files, fileName)
+				os.Remove(fileName)
+			}
+		}
+		return files
+	}
+
+	// Stop at the first failure, keeping what came before it
+	err = Govern([]string{stubConfig}, "", consul, FAIL_FAST)
+	assert.Equal(t, EXIT_FAILED, ExitCode(err))
+	assert.Equal(t, []string{"a.conf"}, written())
+
+	// Write everything that succeeded, and report what did not
+	err = Govern([]string{stubConfig}, "", consul, BEST_EFFORT)
+	assert.Equal(t, EXIT_PARTIAL, ExitCode(err))
+	assert.Equal(t, []string{"a.conf", "c.conf"}, written())
+
+	governErr := err.(*GovernError)
+	assert.Equal(t, 1, len(governErr.Errors))
+	assert.Contains(t, governErr.Error(), "b: Key supplied returned a nil value")
+
+	// Write nothing at all
+	err = Govern([]string{stubConfig}, "", consul, ALL_OR_NOTHING)
+	assert.Equal(t, EXIT_NOTHING_WRITTEN, ExitCode(err))
+	assert.Equal(t, []string{}, written())
+}
+
+func TestWriteErrors(t *testing.T) {
+
+	// A file cannot be written where a folder should be
+	os.MkdirAll("blocked.conf", 0755)
+	defer os.RemoveAll("blocked.conf")
+	defer os.Remove("fine.conf")
+
+	changed, err := MakeConfigFiles(map[string]string{
+		"blocked.conf": "blocked",
+		"fine.conf":    "fine",
+	}, nil)
+
+	writeErr, ok := err.(*WriteError)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(writeErr.Errors))
+	assert.Equal(t, map[string]bool{"fine.conf": true}, changed)
+}
@@ -0,0 +1,244 @@
+// exec.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// Send the reload signal to the child when a file changes
+	ON_CHANGE_SIGNAL string = "signal"
+	// Stop the child and start it again when a file changes
+	ON_CHANGE_RESTART string = "restart"
+	// Leave the child alone when a file changes
+	ON_CHANGE_NONE string = "none"
+
+	EXEC_KILL_TIMEOUT time.Duration = 30 * time.Second
+)
+
+// ParseSignal reads a signal such as SIGHUP, HUP or 1.
+func ParseSignal(name string) (syscall.Signal, error) {
+
+	if number, err := strconv.Atoi(name); err == nil && number > 0 {
+		return syscall.Signal(number), nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("Unknown signal %q", name)
+}
+
+// Supervisor runs the application as a child of governor, and keeps it up to
+// date with its config files.
+type Supervisor struct {
+	command      []string
+	onChange     string
+	reloadSignal syscall.Signal
+	killTimeout  time.Duration
+	env          []string
+
+	cmd    *exec.Cmd
+	exitCh chan error
+}
+
+func NewSupervisor(command []string, onChange string, reloadSignal string, killTimeout time.Duration) (*Supervisor, error) {
+
+	if len(command) == 0 {
+		return nil, fmt.Errorf("No command given to run")
+	}
+
+	switch onChange {
+	case ON_CHANGE_SIGNAL, ON_CHANGE_RESTART, ON_CHANGE_NONE:
+	default:
+		return nil, fmt.Errorf("Unknown -exec-on-change %q, expected signal, restart or none", onChange)
+	}
+
+	sig, err := ParseSignal(reloadSignal)
+	if err != nil {
+		return nil, err
+	}
+
+	if killTimeout <= 0 {
+		return nil, fmt.Errorf("Invalid -exec-kill-timeout %s", killTimeout)
+	}
+
+	return &Supervisor{command: command, onChange: onChange, reloadSignal: sig, killTimeout: killTimeout}, nil
+}
+
+// SetEnv gives the command its own environment, instead of governor's.
+func (supervisor *Supervisor) SetEnv(env []string) {
+	supervisor.env = env
+}
+
+// restart stops the child and starts it again.
+func (supervisor *Supervisor) restart() error {
+	supervisor.stop()
+	return supervisor.start()
+}
+
+func (supervisor *Supervisor) start() error {
+
+	cmd := exec.Command(supervisor.command[0], supervisor.command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = supervisor.env
+
+	// Signals from the terminal only reach the child through us
+	newProcessGroup(cmd)
+
+	log.Println("Starting:", strings.Join(supervisor.command, " "))
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exitCh := make(chan error, 1)
+	go func() {
+		exitCh <- cmd.Wait()
+	}()
+
+	supervisor.cmd = cmd
+	supervisor.exitCh = exitCh
+	return nil
+}
+
+func (supervisor *Supervisor) signal(sig os.Signal) {
+	log.Println("Sending", sig, "to process", supervisor.cmd.Process.Pid)
+	if err := supervisor.cmd.Process.Signal(sig); err != nil {
+		log.Println("Could not signal process:", err)
+	}
+}
+
+// stop asks the child to exit, and kills it if it has not after the timeout.
+func (supervisor *Supervisor) stop() {
+
+	supervisor.signal(syscall.SIGTERM)
+	select {
+	case <-supervisor.exitCh:
+		return
+	case <-time.After(supervisor.killTimeout):
+	}
+
+	// Take anything it started down with it
+	log.Println("Process did not exit in time, killing it")
+	killProcessGroup(supervisor.cmd.Process)
+	<-supervisor.exitCh
+}
+
+// processExitCode gives the exit code of the child, in the same way as a shell.
+func processExitCode(err error) int {
+
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		if err != nil {
+			return EXIT_FAILED
+		}
+		return EXIT_OK
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return exitErr.ExitCode()
+}
+
+// Run starts the child, and supervises it until it exits. Signals are
+// forwarded to the child, and every change is handled as configured. A new
+// environment can only be picked up by restarting the child. It returns the
+// exit code of the child.
+func (supervisor *Supervisor) Run(signalCh <-chan os.Signal, changeCh <-chan struct{}, envCh <-chan []string) (int, error) {
+
+	if err := supervisor.start(); err != nil {
+		return EXIT_FAILED, err
+	}
+
+	for {
+		select {
+		case sig := <-signalCh:
+			supervisor.signal(sig)
+
+		case <-changeCh:
+			switch supervisor.onChange {
+			case ON_CHANGE_SIGNAL:
+				supervisor.signal(supervisor.reloadSignal)
+			case ON_CHANGE_RESTART:
+				log.Println("Config changed, restarting the process")
+				if err := supervisor.restart(); err != nil {
+					return EXIT_FAILED, err
+				}
+			}
+
+		case env := <-envCh:
+			log.Println("Environment changed, restarting the process")
+			supervisor.SetEnv(env)
+			if err := supervisor.restart(); err != nil {
+				return EXIT_FAILED, err
+			}
+
+		case err := <-supervisor.exitCh:
+			code := processExitCode(err)
+			log.Println("Process exited with code", code)
+			return code, nil
+		}
+	}
+}
+
+// Exec runs the application under governor, watching the config file and
+// telling the application whenever its files change. Any keys mapped to its
+// environment are watched too. It returns the exit code of the application.
+func Exec(configFiles []string, format string, client ConsulClient, supervisor *Supervisor, envConfig EnvConfig) int {
+
+	// Several changes at once only need to be handled once
+	changeCh := make(chan struct{}, 1)
+	onChange := func() {
+		select {
+		case changeCh <- struct{}{}:
+		default:
+		}
+	}
+
+	stopCh := make(chan struct{})
+	go func() {
+		if err := WatchWithNotify(configFiles, format, client, stopCh, onChange); err != nil {
+			log.Println(err)
+		}
+	}()
+	defer close(stopCh)
+
+	// Only the latest environment matters
+	var envCh chan []string
+	if envConfig.Enabled() {
+		values, err := FetchEnv(envConfig, client)
+		if err != nil {
+			log.Println("Could not fetch the environment:", err)
+			return ExitCode(err)
+		}
+		supervisor.SetEnv(envConfig.Environ(values))
+
+		envCh = make(chan []string, 1)
+		go WatchEnv(envConfig, values, client, stopCh, func(values map[string]string) {
+			select {
+			case <-envCh:
+			default:
+			}
+			envCh <- envConfig.Environ(values)
+		})
+	}
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, forwardedSignals...)
+	defer signal.Stop(signalCh)
+
+	code, err := supervisor.Run(signalCh, changeCh, envCh)
+	if err != nil {
+		log.Println("Could not run the process:", err)
+	}
+	return code
+}
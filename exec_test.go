@@ -0,0 +1,142 @@
+// exec_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// waitForLines waits until the file has the given number of lines.
+func waitForLines(t *testing.T, fileName string, lines int) {
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		contents, _ := ioutil.ReadFile(fileName)
+		if strings.Count(string(contents), "\n") >= lines {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never had %d line(s)", fileName, lines)
+}
+
+// trappingChild starts, notes that it did in marker, and exits with code
+// when it receives sig.
+func trappingChild(marker string, sig string, code string) []string {
+	return []string{"/bin/sh", "-c",
+		"trap 'exit " + code + "' " + sig + "; echo started >> " + marker + "; while true; do sleep 0.05; done"}
+}
+
+func TestParseSignal(t *testing.T) {
+
+	for _, name := range []string{"SIGHUP", "HUP", "hup", "1"} {
+		sig, err := ParseSignal(name)
+		assert.Nil(t, err)
+		assert.Equal(t, syscall.SIGHUP, sig)
+	}
+
+	_, err := ParseSignal("SIGNOPE")
+	assert.NotNil(t, err)
+}
+
+func TestNewSupervisor(t *testing.T) {
+
+	_, err := NewSupervisor([]string{}, ON_CHANGE_SIGNAL, "HUP", time.Second)
+	assert.NotNil(t, err)
+
+	_, err = NewSupervisor([]string{"true"}, "reload", "HUP", time.Second)
+	assert.NotNil(t, err)
+
+	_, err = NewSupervisor([]string{"true"}, ON_CHANGE_SIGNAL, "NOPE", time.Second)
+	assert.NotNil(t, err)
+
+	_, err = NewSupervisor([]string{"true"}, ON_CHANGE_RESTART, "HUP", time.Second)
+	assert.Nil(t, err)
+}
+
+func TestSupervisorExitCode(t *testing.T) {
+
+	supervisor, err := NewSupervisor([]string{"/bin/sh", "-c", "exit 3"}, ON_CHANGE_NONE, "HUP", time.Second)
+	assert.Nil(t, err)
+
+	code, err := supervisor.Run(make(chan os.Signal), make(chan struct{}), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, code)
+
+	// A command that cannot be started is an error
+	supervisor, err = NewSupervisor([]string{"/no/such/command"}, ON_CHANGE_NONE, "HUP", time.Second)
+	assert.Nil(t, err)
+	_, err = supervisor.Run(make(chan os.Signal), make(chan struct{}), nil)
+	assert.NotNil(t, err)
+}
+
+func TestSupervisorForwardsSignals(t *testing.T) {
+
+	marker := "forward.marker"
+	defer os.Remove(marker)
+
+	supervisor, err := NewSupervisor(trappingChild(marker, "TERM", "5"), ON_CHANGE_NONE, "HUP", time.Second)
+	assert.Nil(t, err)
+
+	signalCh := make(chan os.Signal, 1)
+	go func() {
+		waitForLines(t, marker, 1)
+		signalCh <- syscall.SIGTERM
+	}()
+
+	code, err := supervisor.Run(signalCh, make(chan struct{}), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, code)
+}
+
+func TestSupervisorSignalsOnChange(t *testing.T) {
+
+	marker := "signal.marker"
+	defer os.Remove(marker)
+
+	supervisor, err := NewSupervisor(trappingChild(marker, "USR1", "7"), ON_CHANGE_SIGNAL, "SIGUSR1", time.Second)
+	assert.Nil(t, err)
+
+	changeCh := make(chan struct{}, 1)
+	go func() {
+		waitForLines(t, marker, 1)
+		changeCh <- struct{}{}
+	}()
+
+	code, err := supervisor.Run(make(chan os.Signal), changeCh, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 7, code)
+}
+
+func TestSupervisorRestartsOnChange(t *testing.T) {
+
+	marker := "restart.marker"
+	defer os.Remove(marker)
+
+	supervisor, err := NewSupervisor(trappingChild(marker, "USR1", "0"), ON_CHANGE_RESTART, "HUP", time.Second)
+	assert.Nil(t, err)
+
+	signalCh := make(chan os.Signal, 1)
+	changeCh := make(chan struct{}, 1)
+	go func() {
+		waitForLines(t, marker, 1)
+		changeCh <- struct{}{}
+
+		// Once it started again, tell it to exit
+		waitForLines(t, marker, 2)
+		signalCh <- syscall.SIGUSR1
+	}()
+
+	code, err := supervisor.Run(signalCh, changeCh, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, code)
+
+	contents, err := ioutil.ReadFile(marker)
+	assert.Nil(t, err)
+	assert.Equal(t, "started\nstarted\n", string(contents))
+}
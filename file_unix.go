@@ -0,0 +1,27 @@
+// file_unix.go
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+const (
+	// W_OK, which the syscall package does not name
+	WRITE_ACCESS uint32 = 0x2
+)
+
+// fileOwner gives the user and group that own a file.
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid), true
+	}
+	return 0, 0, false
+}
+
+// writable reports whether governor may write in a folder.
+func writable(folder string) bool {
+	return syscall.Access(folder, WRITE_ACCESS) == nil
+}
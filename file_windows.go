@@ -0,0 +1,20 @@
+// file_windows.go
+//go:build windows
+
+package main
+
+import (
+	"os"
+)
+
+// fileOwner gives the user and group that own a file, which Windows does not
+// have.
+func fileOwner(info os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+// writable reports whether governor may write in a folder. Windows can only
+// tell by trying, which is left to writing the files.
+func writable(folder string) bool {
+	return true
+}
@@ -0,0 +1,192 @@
+// formats.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	FORMAT_JSON string = "json"
+	FORMAT_YAML string = "yaml"
+	FORMAT_TOML string = "toml"
+	FORMAT_HCL  string = "hcl"
+)
+
+// checkConfigFormat checks a format given with -format. An empty format is
+// told from the extension of each file.
+func checkConfigFormat(format string) error {
+
+	switch format {
+	case "", FORMAT_JSON, FORMAT_YAML, FORMAT_TOML, FORMAT_HCL:
+		return nil
+	}
+	return fmt.Errorf("Unknown config format %q, expected json, yaml, toml or hcl", format)
+}
+
+// FileFormat finds the format of a config file. Anything that is not YAML,
+// TOML or HCL is read as JSON, such as the usual govern.conf.
+func FileFormat(fileName string) string {
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		return FORMAT_YAML
+	case ".toml":
+		return FORMAT_TOML
+	case ".hcl":
+		return FORMAT_HCL
+	}
+	return FORMAT_JSON
+}
+
+// configNode is a value read from a config file in any format, along with
+// the line it was found on. Scalars are a string, json.Number, bool or nil.
+type configNode struct {
+	line   int
+	value  interface{}
+	fields []configField
+	items  []*configNode
+	object bool
+	list   bool
+}
+
+type configField struct {
+	key   string
+	line  int
+	value *configNode
+}
+
+func scalarNode(line int, value interface{}) *configNode {
+	return &configNode{line: line, value: value}
+}
+
+func objectNode(line int) *configNode {
+	return &configNode{line: line, object: true}
+}
+
+func listNode(line int) *configNode {
+	return &configNode{line: line, list: true}
+}
+
+// field finds the value of a key of an object.
+func (node *configNode) field(key string) *configNode {
+	for _, field := range node.fields {
+		if field.key == key {
+			return field.value
+		}
+	}
+	return nil
+}
+
+func (node *configNode) set(key string, line int, value *configNode) {
+	node.fields = append(node.fields, configField{key: key, line: line, value: value})
+}
+
+// configSource is a config file as JSON. Files in other formats remember the
+// line each line of JSON came from, so errors can point at the file itself.
+type configSource struct {
+	contents []byte
+	lines    []int
+}
+
+// jsonWriter writes nodes as JSON, starting every value on a line of its own.
+type jsonWriter struct {
+	out   bytes.Buffer
+	lines []int
+}
+
+func (writer *jsonWriter) newline(line int) {
+	writer.out.WriteByte('\n')
+	writer.lines = append(writer.lines, line)
+}
+
+func (writer *jsonWriter) write(node *configNode) {
+
+	switch {
+	case node.object:
+		writer.out.WriteString("{")
+		for i, field := range node.fields {
+			if i > 0 {
+				writer.out.WriteString(",")
+			}
+			writer.newline(field.line)
+			key, _ := json.Marshal(field.key)
+			writer.out.Write(key)
+			writer.out.WriteString(": ")
+			writer.write(field.value)
+		}
+		writer.newline(node.line)
+		writer.out.WriteString("}")
+
+	case node.list:
+		writer.out.WriteString("[")
+		for i, item := range node.items {
+			if i > 0 {
+				writer.out.WriteString(",")
+			}
+			writer.newline(item.line)
+			writer.write(item)
+		}
+		writer.newline(node.line)
+		writer.out.WriteString("]")
+
+	default:
+		value, _ := json.Marshal(node.value)
+		writer.out.Write(value)
+	}
+}
+
+// nodeSource turns the nodes read from a file into JSON.
+func nodeSource(root *configNode) *configSource {
+
+	writer := &jsonWriter{lines: []int{root.line}}
+	writer.write(root)
+	return &configSource{contents: writer.out.Bytes(), lines: writer.lines}
+}
+
+// convertConfig reads the contents of a config file in the given format.
+func convertConfig(fileName string, contents []byte, format string) (*configSource, error) {
+
+	var root *configNode
+	var err error
+	switch format {
+	case FORMAT_YAML:
+		root, err = parseYAML(fileName, string(contents))
+	case FORMAT_TOML:
+		root, err = parseTOML(fileName, string(contents))
+	case FORMAT_HCL:
+		root, err = parseHCL(fileName, string(contents))
+	default:
+		return &configSource{contents: contents}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !root.object {
+		return nil, &ConfigError{File: fileName, Line: root.line, Err: fmt.Errorf("The config must be a map")}
+	}
+	return nodeSource(root), nil
+}
+
+// readConfigSource reads a config file in the given format, or the format of
+// its extension if empty.
+func readConfigSource(fileName string, format string) (*configSource, error) {
+
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, &ConfigError{File: fileName, Err: err}
+	}
+	if format == "" {
+		format = FileFormat(fileName)
+	}
+	return convertConfig(fileName, contents, format)
+}
+
+// syntaxError points at the line of a file that could not be read.
+func syntaxError(fileName string, line int, format string, args ...interface{}) error {
+	return &ConfigError{File: fileName, Line: line, Err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,199 @@
+// formats_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// nodeJSON converts nodes to a document, the way the loader sees them.
+func nodeJSON(t *testing.T, node *configNode) interface{} {
+
+	document, err := parseJSON(string(nodeSource(node).contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+func writeConfigFile(t *testing.T, name string, contents string) string {
+
+	directory, _ := ioutil.TempDir("", "formats")
+	fileName := filepath.Join(directory, name)
+	if err := ioutil.WriteFile(fileName, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestFileFormat(t *testing.T) {
+
+	assert.Equal(t, FORMAT_JSON, FileFormat("govern.conf"))
+	assert.Equal(t, FORMAT_JSON, FileFormat("govern.json"))
+	assert.Equal(t, FORMAT_YAML, FileFormat("govern.yaml"))
+	assert.Equal(t, FORMAT_YAML, FileFormat("/etc/governor/govern.YML"))
+	assert.Equal(t, FORMAT_TOML, FileFormat("govern.toml"))
+	assert.Equal(t, FORMAT_HCL, FileFormat("govern.hcl"))
+
+	assert.Nil(t, checkConfigFormat(""))
+	assert.Nil(t, checkConfigFormat(FORMAT_HCL))
+	assert.NotNil(t, checkConfigFormat("xml"))
+}
+
+func TestGetConfigFromFileFormats(t *testing.T) {
+
+	files := map[string]string{
+		"govern.yaml": `
+# Reloaded whenever it changes
+version: 2
+entries:
+  - key: nginx/config
+    destination: /etc/nginx/nginx.conf
+    command: nginx -s reload
+  - key: ssl/key
+    destination: /etc/ssl/site.key
+    mode: "0600"  # quoted, or it would be a number
+    decode: base64
+`,
+		"govern.toml": `
+# Reloaded whenever it changes
+version = 2
+
+[[entries]]
+key = "nginx/config"
+destination = "/etc/nginx/nginx.conf"
+command = "nginx -s reload"
+
+[[entries]]
+key = "ssl/key"
+destination = "/etc/ssl/site.key"
+mode = "0600"
+decode = "base64"
+`,
+		"govern.hcl": `
+// Reloaded whenever it changes
+version = 2
+
+entry "nginx/config" {
+  key         = "nginx/config"
+  destination = "/etc/nginx/nginx.conf"
+  command     = "nginx -s reload"
+}
+
+entry {
+  key         = "ssl/key"
+  destination = "/etc/ssl/site.key"
+  mode        = "0600" # kept as a string
+  decode      = "base64"
+}
+`,
+	}
+
+	for name, contents := range files {
+		fileName := writeConfigFile(t, name, contents)
+		defer os.RemoveAll(filepath.Dir(fileName))
+
+		config, err := GetConfigFromFiles([]string{fileName}, "")
+		assert.Nil(t, err, name)
+		assert.Equal(t, 2, len(config), name)
+		assert.Equal(t, "/etc/nginx/nginx.conf", config["nginx/config"].Destination, name)
+		assert.Equal(t, "nginx -s reload", config["nginx/config"].Command, name)
+		assert.Equal(t, "0600", config["ssl/key"].Mode, name)
+		assert.Equal(t, "base64", config["ssl/key"].Decode, name)
+	}
+}
+
+func TestConfigFormatSections(t *testing.T) {
+
+	fileName := writeConfigFile(t, "govern.hcl", `
+version = 2
+consul {
+  address = "consul.internal:8500"
+  retry_backoff = "5s"
+}
+entries = []
+`)
+	defer os.RemoveAll(filepath.Dir(fileName))
+
+	config, err := LoadConsulConfig([]string{fileName}, "", ConsulConfig{})
+	assert.Nil(t, err)
+	assert.Equal(t, "consul.internal:8500", config.Address)
+}
+
+func TestConfigFormatErrors(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		contents string
+		message  string
+	}{
+		{
+			name:     "govern.yaml",
+			contents: "version: 2\nentries:\n  - key: a\n    destination: a.conf\n    mod: \"0600\"\n",
+			message:  "govern.yaml:3: json: unknown field \"mod\"",
+		},
+		{
+			name:     "govern.yaml",
+			contents: "version: 2\nentries:\n  - key: a\n    destination: a.conf\n    tree: 1\n",
+			message:  "govern.yaml:5: json: cannot unmarshal number",
+		},
+		{
+			name:     "govern.yaml",
+			contents: "version: 2\nentries:\n  - key: a\n    destination: a.conf\n\n  - key: b\n",
+			message:  "govern.yaml:6: An entry needs a destination",
+		},
+		{
+			name:     "govern.yaml",
+			contents: "version: 2\nentries:\n  - key: a\n     destination: a.conf\n",
+			message:  "govern.yaml:4: mapping values are not allowed in this context",
+		},
+		{
+			name:     "govern.toml",
+			contents: "version = 2\n\n[[entries]]\nkey = \"a\"\ndestination = \"a.conf\"\nmode = 600\n",
+			message:  "govern.toml:6: json: cannot unmarshal number",
+		},
+		{
+			name:     "govern.toml",
+			contents: "version = 2\n[[entries]]\nkey = \"a\"\nkey = \"b\"\n",
+			message:  "govern.toml:4: Key 'entries.key' has already been defined",
+		},
+		{
+			name:     "govern.hcl",
+			contents: "version = 3\nentries = []\n",
+			message:  "govern.hcl:1: Unsupported config version 3",
+		},
+		{
+			name:     "govern.hcl",
+			contents: "version = 2\n\nentry \"a\" {\n  destination = a.conf\n}\n",
+			message:  "govern.hcl:4: Unknown token: 4:17 IDENT a.conf",
+		},
+		{
+			name:     "govern.hcl",
+			contents: "[\"a\"]\n",
+			message:  "govern.hcl:1: expected: IDENT | STRING | ASSIGN | LBRACE got: LBRACK",
+		},
+		{
+			name:     "govern.yaml",
+			contents: "version: 2\nentries:\n  - key: a\n    destination: a.conf\n    mode: 0600\n",
+			message:  "govern.yaml:5: json: cannot unmarshal number",
+		},
+		{
+			name:     "govern.yaml",
+			contents: "- a\n- b\n",
+			message:  "govern.yaml:1: The config must be a map",
+		},
+	}
+
+	for _, c := range cases {
+		fileName := writeConfigFile(t, c.name, c.contents)
+		defer os.RemoveAll(filepath.Dir(fileName))
+
+		_, err := GetConfigFromFiles([]string{fileName}, "")
+		if assert.NotNil(t, err, c.contents) {
+			assert.Contains(t, err.Error(), c.message)
+		}
+	}
+}
@@ -2,15 +2,11 @@
 package main
 
 import (
-	"encoding/json"
-	"flag"
 	"fmt"
-	"github.com/hashicorp/consul/api"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 const (
@@ -18,40 +14,15 @@ const (
 	CONSUL_PORT    string = "CONSUL_PORT"
 )
 
-func GetAttribute(key string, defaultClient *http.Client) string {
-
-	// Get client
-	config := api.DefaultConfig()
-
-	// Override the client if we want more control
-	if defaultClient != nil {
-		config.HttpClient = defaultClient
-	}
-
-	// Check environment variables
-	consulPort := os.Getenv(CONSUL_PORT)
-	if consulPort == "" {
-		consulPort = "8500"
-	}
-
-	if consulAddress := os.Getenv(CONSUL_ADDRESS); consulAddress != "" {
-		config.Address = consulAddress + ":" + consulPort
-	}
-	log.Println("Set the address to: ", config.Address)
-
-	// Load the client
-	client, _ := api.NewClient(config)
-
-	// Key-value end point
-	kv := client.KV()
+func GetAttribute(key string, client ConsulClient) (string, error) {
 
 	log.Println("Attempting to retrieve key: ", key)
-	keyValue, _, err := kv.Get(key, nil)
+	keyValue, _, err := client.Get(key, nil)
 	if err != nil {
-		log.Fatal("Error raised when attempting to get keys from consul: ", err)
+		return "", fmt.Errorf("Error raised when attempting to get keys from consul: %s", err)
 	}
 	if keyValue == nil {
-		log.Fatal("Key supplied returned a nil value - does it exist: ", keyValue)
+		return "", &KeyNotFoundError{Key: key}
 	}
 
 	// Get the value and convert to string
@@ -60,89 +31,241 @@ func GetAttribute(key string, defaultClient *http.Client) string {
 	stringValue := string(byteValue[:])
 	log.Println("Consul returned", stringValue)
 
-	return stringValue
+	return stringValue, nil
 }
 
-func GetConfigFromFile(fileName string) map[string]string {
+// MakeConfigFiles writes every file whose content or permissions differ from
+// what is on disk, and reports for each file whether it changed.
+func MakeConfigFiles(configMap map[string]string, permissions map[string]FilePermissions) (map[string]bool, error) {
 
-	// Open the file
-	contents, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		panic(err)
-	}
+	changed := make(map[string]bool)
+	errs := []error{}
+	for filePath, fileContents := range configMap {
+		filePermissions := permissionsFor(permissions, filePath)
 
-	// Unmarshal the JSON content
-	configMap := make(map[string]string)
-	json.Unmarshal(contents, &configMap)
+		// Leave the file alone if it is already as it should be
+		if fileStatus(filePath, fileContents, filePermissions) == STATUS_UNCHANGED {
+			log.Printf("Config file %s is unchanged, not writing\n", filePath)
+			changed[filePath] = false
+			continue
+		}
+
+		// Does the output folder exist? If not, make it
+		dirPath, _ := filepath.Abs(filepath.Dir(filePath))
+		if _, err := makeFolder(dirPath, filePermissions); err != nil {
+			errs = append(errs, &EntryError{Name: filePath, Err: err})
+			continue
+		}
+
+		// Write the file with its relevant contents
+		log.Printf("Writing config file %s\n", filePath)
+		if err := WriteFileAtomic(filePath, []byte(fileContents), filePermissions); err != nil {
+			errs = append(errs, &EntryError{Name: filePath, Err: err})
+			continue
+		}
+		changed[filePath] = true
+	}
 
-	return configMap
+	if len(errs) > 0 {
+		return changed, &WriteError{Errors: errs}
+	}
+	return changed, nil
 }
 
-func MakeConfigFiles(configMap map[string]string) {
+// checkFileExists checks every config file, which are either files or
+// directories of them.
+func checkFileExists(configFiles []string) error {
+	for _, fileName := range configFiles {
+		if _, err := os.Stat(fileName); err != nil {
+			return &ConfigError{File: fileName, Err: fmt.Errorf("You need to specify a config file that exists: %s", err)}
+		}
+	}
+	return nil
+}
 
-	for filePath, fileContents := range configMap {
+// FetchEntry obtains the content of every file an entry is responsible for.
+func FetchEntry(entry ConfigEntry, client ConsulClient) (map[string]string, error) {
 
-		// Does the output folder exist? If not, make it
-		dirPath, _ := filepath.Abs(filepath.Dir(filePath))
-		src, err := os.Stat(dirPath)
-		if src == nil {
-			// Create folder
-			log.Println("Folder does not exist, making:", dirPath)
-			err := os.MkdirAll(dirPath, 0777)
-			if err != nil {
-				log.Fatal("Unexpected error: ", err)
+	switch {
+	case entry.Tree:
+		tree, err := GetTree(entry.Key, client)
+		if err != nil {
+			return nil, err
+		}
+		for name, value := range tree {
+			if tree[name], err = entry.DecodeValue(value); err != nil {
+				return nil, fmt.Errorf("%s: %s", name, err)
 			}
+		}
+		return entry.TreeFiles(tree), nil
 
-		} else if err != nil {
-			log.Fatal("Unexpected error: ", src, err)
+	case entry.Template != "":
+		configContent, err := RenderTemplate(entry.Template, client)
+		if err != nil {
+			return nil, fmt.Errorf("Error raised when rendering template: %s", err)
 		}
+		return entry.Files(configContent), nil
+	}
 
-		// Write the file with its relevant contents
-		log.Printf("Writing config file %s\n", filePath)
-		ioutil.WriteFile(filePath, []byte(fileContents), 0777)
+	configContent, err := GetAttribute(entry.Key, client)
+	if _, missing := err.(*KeyNotFoundError); missing {
+		defaultContent, defaultErr := entry.DefaultContent()
+		if defaultErr != nil {
+			return nil, defaultErr
+		}
+		if defaultContent != nil {
+			log.Println("Key does not exist, using its default:", entry.Key)
+			return entry.Files(*defaultContent), nil
+		}
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	configContent, err = entry.DecodeValue(configContent)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Files(configContent), nil
 }
 
-func checkFileExists(fileName string) {
-	_, err := os.Stat(fileName)
-	if err != nil {
-		fmt.Println("You need to specify a config file that exists.")
-		panic(err)
+// governPlan is everything a run is going to write or remove.
+type governPlan struct {
+	files       map[string]string
+	permissions map[string]FilePermissions
+	owners      map[string]string
+	succeeded   map[string]ConfigEntry
+	removals    []ConfigEntry
+	errs        []error
+}
+
+// fetchEntries obtains the content of every entry from Consul, without
+// touching the disk.
+func fetchEntries(configMap map[string]ConfigEntry, client ConsulClient, policy FailurePolicy) *governPlan {
+
+	plan := &governPlan{
+		files:       make(map[string]string),
+		permissions: make(map[string]FilePermissions),
+		owners:      make(map[string]string),
+		succeeded:   make(map[string]ConfigEntry),
+		removals:    []ConfigEntry{},
+		errs:        []error{},
+	}
+
+	// Handle the entries in a stable order, so fail-fast is predictable
+	names := []string{}
+	for name := range configMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entry := configMap[name]
+
+		resolved, err := entry.ResolvePermissions()
+		if err != nil {
+			log.Println("Entry failed:", name, err)
+			plan.errs = append(plan.errs, &EntryError{Name: name, Err: err})
+			if policy == FAIL_FAST {
+				break
+			}
+			continue
+		}
+
+		files, err := FetchEntry(entry, client)
+		if err != nil && entry.Optional != "" {
+			var remove bool
+			if remove, err = entry.missingKey(err); err == nil {
+				if remove {
+					plan.removals = append(plan.removals, entry)
+					plan.succeeded[name] = entry
+				}
+				continue
+			}
+		}
+		if err != nil {
+			log.Println("Entry failed:", name, err)
+			plan.errs = append(plan.errs, &EntryError{Name: name, Err: err})
+			if policy == FAIL_FAST {
+				break
+			}
+			continue
+		}
+
+		for filePath, fileContents := range files {
+			plan.files[filePath] = fileContents
+			plan.owners[filePath] = name
+		}
+		for filePath, filePermissions := range entry.FilePermissions(files, resolved) {
+			plan.permissions[filePath] = filePermissions
+		}
+		plan.succeeded[name] = entry
 	}
+
+	return plan
 }
 
-func Govern(configFile string, defaultClient *http.Client) {
+func Govern(configFiles []string, format string, client ConsulClient, policy FailurePolicy) error {
 
 	// Parse the config file
-	configMap := GetConfigFromFile(configFile)
+	configMap, err := GetConfigFromFiles(configFiles, format)
+	if err != nil {
+		return err
+	}
 
 	// Obtain the content from Consul and place in map
-	var configContent string
-	outputConfigMap := make(map[string]string)
-
-	for consulKey, configPath := range configMap {
-		configContent = GetAttribute(consulKey, defaultClient)
+	plan := fetchEntries(configMap, client, policy)
+	errs := plan.errs
 
-		outputConfigMap[configPath] = configContent
+	// Nothing is written unless every entry could be fetched
+	if policy == ALL_OR_NOTHING && len(errs) > 0 {
+		log.Println("Not writing any files, as not every entry succeeded")
+		return &GovernError{Policy: policy, Errors: errs}
 	}
 
-	// Make the config files
-	MakeConfigFiles(outputConfigMap)
-}
+	// Make the config files, all together if nothing may be left half done
+	var changed map[string]bool
+	if policy == ALL_OR_NOTHING {
+		changed, err = CommitConfigFiles(plan.files, plan.permissions)
+	} else {
+		changed, err = MakeConfigFiles(plan.files, plan.permissions)
+	}
+	if writeErr, ok := err.(*WriteError); ok {
+		errs = append(errs, writeErr.Errors...)
+		if policy == ALL_OR_NOTHING {
+			return &GovernError{Policy: policy, Errors: errs}
+		}
+	}
 
-func main() {
+	// Remove the files of keys that have disappeared
+	for _, entry := range plan.succeeded {
+		if entry.Tree && entry.Prune {
+			for _, filePath := range entry.PruneFiles(plan.files) {
+				changed[filePath] = true
+			}
+		}
+	}
 
-	// Definitions of allowed input flags
-	configFilePtr := flag.String("c", "govern.conf", "Config file.")
+	// Remove the files of optional keys that have disappeared
+	for _, entry := range plan.removals {
+		removed, err := entry.RemoveFiles()
+		if err != nil {
+			errs = append(errs, &EntryError{Name: entry.Name, Err: err})
+		}
+		for _, filePath := range removed {
+			changed[filePath] = true
+		}
+	}
 
-	// Parse all the flags based on definitions
-	flag.Parse()
+	// Run the commands of the files that were written
+	RunEntryCommands(plan.succeeded, changed)
 
-	// Check config file exists
-	checkFileExists(*configFilePtr)
+	if len(errs) > 0 {
+		return &GovernError{Policy: policy, Errors: errs}
+	}
+	return nil
+}
 
-	// Runtime routine
-	log.Println("Using config file: ", *configFilePtr)
-	Govern(*configFilePtr, nil)
+func main() {
+	os.Exit(RunCLI(os.Args[1:]))
 }
@@ -4,6 +4,7 @@ package main
 import (
 	"encoding/base64"
 	"fmt"
+	"github.com/hashicorp/consul/api"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"net/http"
@@ -11,13 +12,103 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type StubConfig struct {
 	key, value string
 }
 
+// fakeConsul stands in for Consul, serving key-values from memory and
+// counting the requests made to it
+type fakeConsul struct {
+	mu       sync.Mutex
+	values   map[string]string
+	indexes  map[string]uint64
+	requests int
+}
+
+// stubConsul serves the given key-values
+func stubConsul(values map[string]string) *fakeConsul {
+	return &fakeConsul{values: values, indexes: make(map[string]uint64)}
+}
+
+// modifyIndex is the index a key was last written at, 1 unless written since
+func (consul *fakeConsul) modifyIndex(key string) uint64 {
+	if index, ok := consul.indexes[key]; ok {
+		return index
+	}
+	return 1
+}
+
+// newTestClient makes a real client that sends its requests through httpClient
+func newTestClient(httpClient *http.Client) ConsulClient {
+	client, err := NewClient(ConsulConfig{}, httpClient)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
+func (consul *fakeConsul) Requests() int {
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	return consul.requests
+}
+
+func (consul *fakeConsul) Get(key string, options *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+
+	// Simulate a blocking query that times out without a change
+	if options != nil && options.WaitIndex >= 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	consul.requests++
+
+	meta := &api.QueryMeta{LastIndex: 1}
+	value, ok := consul.values[key]
+	if !ok {
+		return nil, meta, nil
+	}
+	return &api.KVPair{Key: key, Value: []byte(value), CreateIndex: 1, ModifyIndex: consul.modifyIndex(key)}, meta, nil
+}
+
+func (consul *fakeConsul) List(prefix string, options *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	consul.requests++
+
+	// Collect the matching keys in a stable order
+	keys := []string{}
+	for key := range consul.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	keyValues := api.KVPairs{}
+	for _, key := range keys {
+		keyValues = append(keyValues, &api.KVPair{Key: key, Value: []byte(consul.values[key]), CreateIndex: 1, ModifyIndex: 1})
+	}
+	return keyValues, &api.QueryMeta{LastIndex: 1}, nil
+}
+
+func (consul *fakeConsul) Service(service string, tag string, passingOnly bool, options *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	return nil, nil, fmt.Errorf("No services are registered with the fake Consul")
+}
+
+func (consul *fakeConsul) Services(options *api.QueryOptions) (map[string][]string, *api.QueryMeta, error) {
+	return nil, nil, fmt.Errorf("No services are registered with the fake Consul")
+}
+
 func TestConsulAccess(t *testing.T) {
 
 	expected := StubConfig{key: "ssl_key", value: "/path/to/key"}
@@ -51,9 +142,11 @@ func TestConsulAccess(t *testing.T) {
 
 	// Make a http.Client with the transport
 	httpClient := &http.Client{Transport: transport}
+	client := newTestClient(httpClient)
 
-	attr := GetAttribute(expected.key, httpClient)
+	attr, err := GetAttribute(expected.key, client)
 
+	assert.Nil(t, err)
 	assert.Equal(t, attr, expected.value, "The two words should be equal")
 
 }
@@ -74,13 +167,47 @@ func TestParseFileCorrectly(t *testing.T) {
 	defer os.Remove(stubFileName)
 
 	// Load the config file
-	config := GetConfigFromFile(stubFileName)
+	config, err := GetConfigFromFiles([]string{stubFileName}, "")
+	assert.Nil(t, err)
 
 	// Check that the key exists
 	value, ok := config[expected.key]
 	assert.True(t, ok)
-	assert.Equal(t, value, expected.value)
+	assert.Equal(t, value.Destination, expected.value)
+
+}
+
+func TestParseEntryObject(t *testing.T) {
+
+	// Make a stub file with both forms of entry
+	stubFileName := "config_file.conf"
+	stubContent := `{
+		"ssl_key": "/path/to/key",
+		"nginx": {
+			"destination": "/etc/nginx/nginx.conf",
+			"command": "nginx -s reload",
+			"command_timeout": "10s",
+			"always": true
+		}
+	}`
+	err := ioutil.WriteFile(stubFileName, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubFileName)
 
+	config, err := GetConfigFromFiles([]string{stubFileName}, "")
+	assert.Nil(t, err)
+
+	assert.Equal(t, ConfigEntry{Name: "ssl_key", Key: "ssl_key", Destination: "/path/to/key"}, config["ssl_key"])
+	assert.Equal(t, ConfigEntry{
+		Name:           "nginx",
+		Key:            "nginx",
+		Destination:    "/etc/nginx/nginx.conf",
+		Command:        "nginx -s reload",
+		CommandTimeout: "10s",
+		Always:         true,
+	}, config["nginx"])
 }
 
 func TestConsulWriteToDisk(t *testing.T) {
@@ -92,15 +219,22 @@ func TestConsulWriteToDisk(t *testing.T) {
 	}
 
 	// Generate a file from the stub data
-	MakeConfigFiles(stubMap)
+	changed, err := MakeConfigFiles(stubMap, nil)
+	assert.Nil(t, err)
+	assert.True(t, changed[expected_file])
 
 	// Check that the config file was created
-	_, err := os.Stat(expected_file)
+	_, err = os.Stat(expected_file)
 	assert.Nil(t, err)
 
 	// This is only called if the first assertion passes
 	defer os.Remove(expected_file)
 
+	// Writing the same content again is not a change
+	changed, err = MakeConfigFiles(stubMap, nil)
+	assert.Nil(t, err)
+	assert.False(t, changed[expected_file])
+
 	// Check the contents is sensible
 	contents, err := ioutil.ReadFile(expected_file)
 	if err != nil {
@@ -126,13 +260,14 @@ func TestWriteToNewFolder(t *testing.T) {
 	}
 
 	// Generate a file from the stub data
-	MakeConfigFiles(stubMap)
+	_, err := MakeConfigFiles(stubMap, nil)
+	assert.Nil(t, err)
 
 	// Need to clean up the generated folder after the test
 	filePath, _ := filepath.Abs(filepath.Dir(expected_file))
 
 	// Check that the config file was created
-	_, err := os.Stat(expected_file)
+	_, err = os.Stat(expected_file)
 	assert.Nil(t, err)
 
 	// Clean up files/folders. This is only called if the first assertion passes
@@ -194,6 +329,7 @@ func TestGovern(t *testing.T) {
 
 	// Make a http.Client with the transport
 	httpClient := &http.Client{Transport: transport}
+	client := newTestClient(httpClient)
 
 	// Make a stub config file
 	stubConfig := "governor.conf"
@@ -207,7 +343,8 @@ func TestGovern(t *testing.T) {
 	defer os.Remove(stubConfig)
 
 	// Lets run the routine
-	Govern(stubConfig, httpClient)
+	err = Govern([]string{stubConfig}, "", client, FAIL_FAST)
+	assert.Nil(t, err)
 
 	// Check that the config file was created
 	_, err = os.Stat(stubGovernorConfig.value)
@@ -0,0 +1,158 @@
+// hcl.go
+package main
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/parser"
+	"github.com/hashicorp/hcl/hcl/token"
+	"strconv"
+	"strings"
+)
+
+// hclReader maps the syntax tree of an HCL file onto nodes.
+type hclReader struct {
+	fileName string
+}
+
+func (reader *hclReader) errorf(line int, format string, args ...interface{}) error {
+	return syntaxError(reader.fileName, line, format, args...)
+}
+
+func (reader *hclReader) readValue(value ast.Node) (*configNode, error) {
+
+	line := value.Pos().Line
+	switch value := value.(type) {
+	case *ast.ObjectType:
+		return reader.readBody(line, value.List, false)
+
+	case *ast.ListType:
+		node := listNode(line)
+		for _, item := range value.List {
+			child, err := reader.readValue(item)
+			if err != nil {
+				return nil, err
+			}
+			node.items = append(node.items, child)
+		}
+		return node, nil
+
+	case *ast.LiteralType:
+		switch value.Token.Type {
+		case token.NUMBER:
+			number, err := strconv.ParseInt(value.Token.Text, 0, 64)
+			if err != nil {
+				return nil, reader.errorf(line, "Invalid number %s", value.Token.Text)
+			}
+			return scalarNode(line, json.Number(strconv.FormatInt(number, 10))), nil
+		case token.FLOAT:
+			number, err := strconv.ParseFloat(value.Token.Text, 64)
+			if err != nil {
+				return nil, reader.errorf(line, "Invalid number %s", value.Token.Text)
+			}
+			return scalarNode(line, json.Number(strconv.FormatFloat(number, 'g', -1, 64))), nil
+		case token.BOOL, token.STRING, token.HEREDOC:
+			return scalarNode(line, value.Token.Value()), nil
+		}
+	}
+	return nil, reader.errorf(line, "Unsupported value")
+}
+
+// readBody reads the items of a file or an object, with blocks nested under
+// their key and labels.
+func (reader *hclReader) readBody(line int, list *ast.ObjectList, topLevel bool) (*configNode, error) {
+
+	node := objectNode(line)
+	for _, item := range list.Items {
+		keyLine := item.Keys[0].Pos().Line
+		key := item.Keys[0].Token.Value().(string)
+		value, err := reader.readValue(item.Val)
+		if err != nil {
+			return nil, err
+		}
+
+		if item.Assign.IsValid() {
+			if node.field(key) != nil {
+				return nil, reader.errorf(keyLine, "Duplicate key %q", key)
+			}
+			node.set(key, keyLine, value)
+			continue
+		}
+
+		labels := []string{}
+		for _, label := range item.Keys[1:] {
+			labels = append(labels, label.Token.Value().(string))
+		}
+		if err := reader.addBlock(node, key, labels, keyLine, value, topLevel); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// addBlock adds the body of a block to node. Entries are named by their
+// label, and any other labels nest objects within the block.
+func (reader *hclReader) addBlock(node *configNode, key string, labels []string, line int, body *configNode, topLevel bool) error {
+
+	if topLevel && key == "entry" {
+		if len(labels) > 1 {
+			return reader.errorf(line, "An entry block can only have a single label, its name")
+		}
+		if len(labels) == 1 {
+			if body.field("name") != nil {
+				return reader.errorf(line, "Entry %q is named by both its label and its name", labels[0])
+			}
+			name := configField{key: "name", line: line, value: scalarNode(line, labels[0])}
+			body.fields = append([]configField{name}, body.fields...)
+		}
+
+		entries := node.field("entries")
+		if entries == nil {
+			entries = listNode(line)
+			node.set("entries", line, entries)
+		}
+		if !entries.list {
+			return reader.errorf(line, "entry blocks cannot be used along with entries that is not a list")
+		}
+		entries.items = append(entries.items, body)
+		return nil
+	}
+
+	path := append([]string{key}, labels...)
+	parent := node
+	for i, name := range path[:len(path)-1] {
+		child := parent.field(name)
+		if child == nil {
+			child = objectNode(line)
+			parent.set(name, line, child)
+		}
+		if !child.object {
+			return reader.errorf(line, "Block %q is already defined as a value", strings.Join(path[:i+1], " "))
+		}
+		parent = child
+	}
+	if parent.field(path[len(path)-1]) != nil {
+		return reader.errorf(line, "Block %q is defined twice", strings.Join(path, " "))
+	}
+	parent.set(path[len(path)-1], line, body)
+	return nil
+}
+
+// parseHCL reads an HCL file into nodes.
+func parseHCL(fileName string, src string) (*configNode, error) {
+
+	file, err := parser.Parse([]byte(src))
+	if err != nil {
+		if posError, ok := err.(*parser.PosError); ok {
+			return nil, syntaxError(fileName, posError.Pos.Line, "%s", posError.Err)
+		}
+		return nil, &ConfigError{File: fileName, Err: err}
+	}
+
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, syntaxError(fileName, 1, "The config must be a map")
+	}
+	reader := &hclReader{fileName: fileName}
+	return reader.readBody(1, list, true)
+}
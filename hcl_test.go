@@ -0,0 +1,100 @@
+// hcl_test.go
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseHCL(t *testing.T) {
+
+	node, err := parseHCL("govern.hcl", `
+# A comment
+name = "web" // and another
+port = 8080
+enabled = true
+/* a comment
+   over several lines */
+"quoted key" = "${var.name} \"quoted\""
+list = [1, "two",]
+object = { a = 1, b = "c" }
+script = <<EOF
+#!/bin/sh
+echo hi
+EOF
+indented = <<-EOF
+    one
+      two
+    EOF
+
+consul {
+  address = "localhost:8500"
+}
+
+service "web" "primary" {
+  port = 80
+}
+
+service "web" "backup" {
+  port = 81
+}
+
+entry "motd" {
+  destination = "/etc/motd"
+}
+
+entry {
+  name = "hosts"
+}
+`)
+	assert.Nil(t, err)
+
+	expected := map[string]interface{}{
+		"name":       "web",
+		"port":       json.Number("8080"),
+		"enabled":    true,
+		"quoted key": `${var.name} "quoted"`,
+		"list":       []interface{}{json.Number("1"), "two"},
+		"object":     map[string]interface{}{"a": json.Number("1"), "b": "c"},
+		"script":     "#!/bin/sh\necho hi\n",
+		"indented":   "one\n  two\n",
+		"consul":     map[string]interface{}{"address": "localhost:8500"},
+		"service": map[string]interface{}{
+			"web": map[string]interface{}{
+				"primary": map[string]interface{}{"port": json.Number("80")},
+				"backup":  map[string]interface{}{"port": json.Number("81")},
+			},
+		},
+		"entries": []interface{}{
+			map[string]interface{}{"name": "motd", "destination": "/etc/motd"},
+			map[string]interface{}{"name": "hosts"},
+		},
+	}
+	assert.Equal(t, expected, nodeJSON(t, node))
+}
+
+func TestParseHCLErrors(t *testing.T) {
+
+	cases := []struct {
+		contents string
+		message  string
+	}{
+		{"a = 1\na = 2\n", "govern.hcl:2: Duplicate key \"a\""},
+		{"consul {}\nconsul {}\n", "govern.hcl:2: Block \"consul\" is defined twice"},
+		{"entry \"a\" {\n  name = \"b\"\n}\n", "govern.hcl:1: Entry \"a\" is named by both its label and its name"},
+		{"entry \"a\" \"b\" {}\n", "govern.hcl:1: An entry block can only have a single label"},
+		{"a = <<EOF\nb\n", "govern.hcl:3: heredoc not terminated"},
+		{"a {\n  b = 1\n", "govern.hcl:3: object expected closing RBRACE got: EOF"},
+		{"a = 99999999999999999999\n", "govern.hcl:1: Invalid number 99999999999999999999"},
+		{"a = \"b\n", "govern.hcl:1: literal not terminated"},
+		{"/* a\n", "govern.hcl:2: comment not terminated"},
+	}
+
+	for _, c := range cases {
+		_, err := parseHCL("govern.hcl", c.contents)
+		if assert.NotNil(t, err, c.contents) {
+			assert.Contains(t, err.Error(), c.message)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// include.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configExtensions are the files read from a directory of config files
+var configExtensions = map[string]bool{
+	".json": true, ".conf": true, ".yaml": true, ".yml": true, ".toml": true, ".hcl": true,
+}
+
+// expandConfigPath lists the config files a path stands for: the file itself,
+// or the files of a directory that have a config extension, by name.
+func expandConfigPath(path string) ([]string, error) {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	fileNames := []string{}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || strings.HasPrefix(name, ".") || !configExtensions[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+		fileNames = append(fileNames, filepath.Join(path, name))
+	}
+	return fileNames, nil
+}
+
+// configIncludes lists the files that a versioned config file includes. Each
+// include is a file, a directory or a pattern, relative to the file itself.
+func configIncludes(fileName string, source *configSource) ([]string, error) {
+
+	loader := &configLoader{fileName: fileName, contents: source.contents, lines: source.lines}
+
+	var topLevel map[string]json.RawMessage
+	if err := json.Unmarshal(source.contents, &topLevel); err != nil {
+		return nil, loader.errorAt(0, err)
+	}
+	var version int
+	raw, ok := topLevel["include"]
+	if !ok || json.Unmarshal(topLevel["version"], &version) != nil || version != CONFIG_VERSION_ENTRIES {
+		return nil, nil
+	}
+	offset := int64(bytes.Index(source.contents, raw))
+
+	// A single include can be given without a list
+	var includes []string
+	if json.Unmarshal(raw, &includes) != nil {
+		var include string
+		if err := json.Unmarshal(raw, &include); err != nil {
+			return nil, loader.errorAt(offset, fmt.Errorf("include must be a file, directory or pattern, or a list of them"))
+		}
+		includes = []string{include}
+	}
+
+	fileNames := []string{}
+	for _, include := range includes {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(fileName), include)
+		}
+
+		// Patterns can match nothing, as a directory can be empty
+		if strings.ContainsAny(include, "*?[") {
+			matches, err := filepath.Glob(include)
+			if err != nil {
+				return nil, loader.errorAt(offset, fmt.Errorf("Invalid include %q: %s", include, err))
+			}
+			for _, match := range matches {
+				if info, err := os.Stat(match); err == nil && !info.IsDir() {
+					fileNames = append(fileNames, match)
+				}
+			}
+			continue
+		}
+
+		expanded, err := expandConfigPath(include)
+		if os.IsNotExist(err) {
+			return nil, loader.errorAt(offset, fmt.Errorf("Included file %s does not exist", include))
+		}
+		if err != nil {
+			return nil, loader.errorAt(offset, err)
+		}
+		fileNames = append(fileNames, expanded...)
+	}
+	return fileNames, nil
+}
+
+// readConfigFiles reads every file the config files stand for, each followed
+// by the files it includes. A file is only read once, however often it is
+// named or included. The format only applies to the files named themselves,
+// and files of a directory or included ones are read by their extension.
+func readConfigFiles(configFiles []string, format string, read func(fileName string, source *configSource) error) error {
+
+	seen := make(map[string]bool)
+	var readFile func(fileName string, format string) error
+	readFile = func(fileName string, format string) error {
+
+		absolute, err := filepath.Abs(fileName)
+		if err != nil {
+			return &ConfigError{File: fileName, Err: err}
+		}
+		if seen[absolute] {
+			return nil
+		}
+		seen[absolute] = true
+
+		source, err := readConfigSource(fileName, format)
+		if err != nil {
+			return err
+		}
+		if err := read(fileName, source); err != nil {
+			return err
+		}
+
+		includes, err := configIncludes(fileName, source)
+		if err != nil {
+			return err
+		}
+		for _, include := range includes {
+			if err := readFile(include, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, path := range configFiles {
+		fileNames, err := expandConfigPath(path)
+		if err != nil {
+			return &ConfigError{File: path, Err: err}
+		}
+		for _, fileName := range fileNames {
+			fileFormat := ""
+			if fileName == path {
+				fileFormat = format
+			}
+			if err := readFile(fileName, fileFormat); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+// include_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfigDirectory writes files into a new directory, which may be nested.
+func writeConfigDirectory(t *testing.T, files map[string]string) string {
+
+	directory, _ := ioutil.TempDir("", "include")
+	for name, contents := range files {
+		fileName := filepath.Join(directory, name)
+		os.MkdirAll(filepath.Dir(fileName), 0755)
+		if err := ioutil.WriteFile(fileName, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return directory
+}
+
+func TestConfigDirectory(t *testing.T) {
+
+	directory := writeConfigDirectory(t, map[string]string{
+		"conf.d/10-web.json":  `{"version": 2, "entries": [{"key": "web", "destination": "/etc/web.conf"}]}`,
+		"conf.d/20-db.yaml":   "version: 2\nentries:\n  - key: db\n    destination: /etc/db.conf\n",
+		"conf.d/README.md":    "Not a config file",
+		"conf.d/.hidden.json": `{"hidden": "/etc/hidden.conf"}`,
+		"govern.conf":         `{"ssl_key": "/etc/ssl/site.key"}`,
+	})
+	defer os.RemoveAll(directory)
+
+	config, err := GetConfigFromFiles([]string{filepath.Join(directory, "conf.d")}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(config))
+	assert.Equal(t, "/etc/web.conf", config["web"].Destination)
+	assert.Equal(t, "/etc/db.conf", config["db"].Destination)
+
+	// Several paths are merged, as when -c is repeated
+	config, err = GetConfigFromFiles([]string{filepath.Join(directory, "govern.conf"), filepath.Join(directory, "conf.d")}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(config))
+	assert.Equal(t, "/etc/ssl/site.key", config["ssl_key"].Destination)
+
+	// Naming a file twice reads it once
+	config, err = GetConfigFromFiles([]string{filepath.Join(directory, "conf.d"), filepath.Join(directory, "conf.d/10-web.json")}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(config))
+}
+
+func TestConfigInclude(t *testing.T) {
+
+	directory := writeConfigDirectory(t, map[string]string{
+		"govern.conf": `{
+			"version": 2,
+			"include": ["teams/*.json", "shared"],
+			"entries": [{"key": "motd", "destination": "/etc/motd"}]
+		}`,
+		"teams/web.json":    `{"version": 2, "entries": [{"key": "web", "destination": "/etc/web.conf"}]}`,
+		"teams/db.json":     `{"version": 2, "include": "../govern.conf", "entries": [{"key": "db", "destination": "/etc/db.conf"}]}`,
+		"shared/ssl.hcl":    "version = 2\nentry \"ssl\" {\n  key = \"ssl/key\"\n  destination = \"/etc/ssl/site.key\"\n}\n",
+		"empty/govern.conf": `{"version": 2, "include": "none/*.json", "entries": []}`,
+	})
+	defer os.RemoveAll(directory)
+
+	// Files that include each other are still read once
+	config, err := GetConfigFromFiles([]string{filepath.Join(directory, "govern.conf")}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(config))
+	assert.Equal(t, "/etc/web.conf", config["web"].Destination)
+	assert.Equal(t, "/etc/db.conf", config["db"].Destination)
+	assert.Equal(t, "/etc/ssl/site.key", config["ssl"].Destination)
+
+	// A pattern can match nothing
+	config, err = GetConfigFromFiles([]string{filepath.Join(directory, "empty/govern.conf")}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(config))
+}
+
+func TestConfigFilesErrors(t *testing.T) {
+
+	directory := writeConfigDirectory(t, map[string]string{
+		"a.json":        `{"version": 2, "entries": [{"key": "a", "destination": "/etc/shared.conf"}]}`,
+		"b.json":        `{"version": 2, "entries": [{"key": "b", "destination": "/etc/shared.conf"}]}`,
+		"c.json":        `{"version": 2, "entries": [{"key": "a", "destination": "/etc/c.conf"}]}`,
+		"d.json":        `{"version": 2, "entries": [{"key": "d", "tree": true, "prune": true, "destination": "/etc"}]}`,
+		"missing.json":  "{\n  \"version\": 2,\n  \"include\": \"nowhere.json\"\n}",
+		"invalid.json":  `{"version": 2, "include": {"file": "a.json"}}`,
+		"consul-a.json": `{"version": 2, "consul": {"address": "a:8500"}, "entries": []}`,
+		"consul-b.json": `{"version": 2, "consul": {"address": "b:8500"}, "entries": []}`,
+	})
+	defer os.RemoveAll(directory)
+	paths := func(names ...string) []string {
+		paths := []string{}
+		for _, name := range names {
+			paths = append(paths, filepath.Join(directory, name))
+		}
+		return paths
+	}
+	path := func(name string) string {
+		return filepath.Join(directory, name)
+	}
+
+	cases := []struct {
+		configFiles []string
+		message     string
+	}{
+		{paths("a.json", "b.json"), `b.json:1:28: Destination "/etc/shared.conf" is also written by "a" in ` + path("a.json")},
+		{paths("a.json", "c.json"), `c.json:1:28: Duplicate entry "a", also defined in ` + path("a.json")},
+		{paths("a.json", "d.json"), `d.json:1:28: Destination "/etc/shared.conf" of "a" in ` + path("a.json") + ` would be pruned`},
+		{paths("missing.json"), `missing.json:3:14: Included file ` + path("nowhere.json") + ` does not exist`},
+		{paths("invalid.json"), `invalid.json:1:27: include must be a file, directory or pattern, or a list of them`},
+		{paths("a.json", "nowhere"), `nowhere: stat`},
+	}
+
+	for _, c := range cases {
+		_, err := GetConfigFromFiles(c.configFiles, "")
+		if assert.NotNil(t, err, c.configFiles) {
+			assert.Contains(t, err.Error(), c.message)
+		}
+	}
+
+	// Settings can only be given once
+	_, err := GetConsulConfigFromFiles(paths("consul-a.json", "consul-b.json"), "")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "The consul section is already given in "+path("consul-a.json"))
+	}
+}
+
+func TestRepeatedConfigFlag(t *testing.T) {
+
+	directory := writeConfigDirectory(t, map[string]string{
+		"a.json":   `{"version": 2, "entries": [{"key": "a", "destination": "a.conf"}]}`,
+		"b.yaml":   "version: 2\nentries:\n  - key: b\n    destination: b.conf\n",
+		"c:d.json": `{"version": 2, "entries": [{"key": "c", "destination": "c.conf"}]}`,
+	})
+	defer os.RemoveAll(directory)
+
+	var code int
+	output := captureStdout(func() {
+		code = RunCLI([]string{"validate", "-c", filepath.Join(directory, "a.json"), "-c", filepath.Join(directory, "b.yaml")})
+	})
+	assert.Equal(t, EXIT_OK, code)
+	assert.Contains(t, output, "b.yaml is valid, with 2 entries")
+
+	// A path is used as it is, even if it holds the path list separator
+	output = captureStdout(func() {
+		code = RunCLI([]string{"validate", "-c", filepath.Join(directory, "c:d.json")})
+	})
+	assert.Equal(t, EXIT_OK, code)
+	assert.Contains(t, output, "c:d.json is valid, with 1 entries")
+}
+
+func TestConfigFormatFlag(t *testing.T) {
+
+	directory := writeConfigDirectory(t, map[string]string{
+		"govern.conf":     "version: 2\ninclude: [shared.json, conf.d]\nentries:\n  - key: a\n    destination: a.conf\n",
+		"shared.json":     `{"version": 2, "entries": [{"key": "b", "destination": "b.conf"}]}`,
+		"conf.d/c.json":   `{"version": 2, "entries": [{"key": "c", "destination": "c.conf"}]}`,
+		"conf.d/d.yaml":   "version: 2\nentries:\n  - key: d\n    destination: d.conf\n",
+		"other/e.conf":    `{"version": 2, "entries": [{"key": "e", "destination": "e.conf"}]}`,
+		"other/f.unknown": "not read",
+	})
+	defer os.RemoveAll(directory)
+
+	// The format only applies to the files given with -c, and the files they
+	// include or that a directory holds are read by their extension
+	config, err := GetConfigFromFiles([]string{filepath.Join(directory, "govern.conf"), filepath.Join(directory, "other")}, FORMAT_YAML)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(config))
+
+	var code int
+	output := captureStdout(func() {
+		code = RunCLI([]string{"validate", "-c", filepath.Join(directory, "govern.conf"), "-format", "yaml"})
+	})
+	assert.Equal(t, EXIT_OK, code)
+	assert.Contains(t, output, "govern.conf is valid, with 4 entries")
+
+	// Without the flag, govern.conf is read as JSON
+	_, err = GetConfigFromFiles([]string{filepath.Join(directory, "govern.conf")}, "")
+	assert.NotNil(t, err)
+}
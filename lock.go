@@ -0,0 +1,96 @@
+// lock.go
+package main
+
+import (
+	"log"
+)
+
+// Locker is a lock held in Consul, such as an *api.Lock. Lock blocks until it
+// is acquired or stopCh closes, and the channel it returns is closed once the
+// lock is lost.
+type Locker interface {
+	Lock(stopCh <-chan struct{}) (<-chan struct{}, error)
+	Unlock() error
+}
+
+// LockKey makes a lock on key, shared by every governor that uses the same key.
+func (client *Client) LockKey(key string) (Locker, error) {
+	return client.api.LockKey(key)
+}
+
+// acquire waits for the lock, retrying on errors. It returns nil if stopCh
+// closed first.
+func acquire(locker Locker, stopCh <-chan struct{}) <-chan struct{} {
+
+	for {
+		log.Println("Waiting for the lock")
+		leaderCh, err := locker.Lock(stopCh)
+		if err == nil {
+			if leaderCh != nil {
+				log.Println("Acquired the lock")
+			}
+			return leaderCh
+		}
+
+		log.Println("Could not acquire the lock, retrying:", err)
+		if !waitToRetry(stopCh) {
+			return nil
+		}
+	}
+}
+
+func release(locker Locker) {
+	if err := locker.Unlock(); err != nil {
+		log.Println("Could not release the lock:", err)
+	}
+}
+
+// GovernWithLock writes the files once the lock is held, so that governors
+// sharing a filesystem take turns rather than race.
+func GovernWithLock(configFiles []string, format string, client ConsulClient, locker Locker, policy FailurePolicy, stopCh <-chan struct{}) error {
+
+	if acquire(locker, stopCh) == nil {
+		return nil
+	}
+	defer release(locker)
+	return Govern(configFiles, format, client, policy)
+}
+
+// WatchWithLock only watches and writes files while holding the lock. The
+// others stand by, and the first to acquire the lock once it is lost or
+// released takes over.
+func WatchWithLock(configFiles []string, format string, client ConsulClient, locker Locker, stopCh <-chan struct{}) error {
+
+	for {
+		leaderCh := acquire(locker, stopCh)
+		if leaderCh == nil {
+			return nil
+		}
+
+		// Stop watching as soon as we are told to, or are no longer the leader
+		leaderStopCh := make(chan struct{})
+		doneCh := make(chan struct{})
+		go func() {
+			select {
+			case <-stopCh:
+			case <-leaderCh:
+				log.Println("Lost the lock, standing by")
+			case <-doneCh:
+			}
+			close(leaderStopCh)
+		}()
+
+		err := WatchWithNotify(configFiles, format, client, leaderStopCh, nil)
+		close(doneCh)
+		release(locker)
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-stopCh:
+			return nil
+		default:
+		}
+	}
+}
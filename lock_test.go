@@ -0,0 +1,137 @@
+// lock_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLocker hands out the lock whenever a leader channel is sent to it
+type fakeLocker struct {
+	grantCh chan chan struct{}
+
+	mu      sync.Mutex
+	unlocks int
+}
+
+func newFakeLocker() *fakeLocker {
+	return &fakeLocker{grantCh: make(chan chan struct{}, 1)}
+}
+
+func (locker *fakeLocker) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	select {
+	case leaderCh := <-locker.grantCh:
+		return leaderCh, nil
+	case <-stopCh:
+		return nil, nil
+	}
+}
+
+func (locker *fakeLocker) Unlock() error {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	locker.unlocks++
+	return nil
+}
+
+func (locker *fakeLocker) Unlocks() int {
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	return locker.unlocks
+}
+
+// waitForUnlocks waits until the lock was released the given number of times.
+func waitForUnlocks(t *testing.T, locker *fakeLocker, unlocks int) {
+
+	for i := 0; i < 200; i++ {
+		if locker.Unlocks() >= unlocks {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("The lock was never released %d time(s)", unlocks)
+}
+
+func writeLockConfig() (string, string) {
+
+	stubConfig := "lock.conf"
+	stubFile := "locked.conf"
+	err := ioutil.WriteFile(stubConfig, []byte(`{"lock_key": "`+stubFile+`"}`), 0644)
+	if err != nil {
+		panic(err)
+	}
+	return stubConfig, stubFile
+}
+
+func TestGovernWithLock(t *testing.T) {
+
+	stubConfig, stubFile := writeLockConfig()
+	defer os.Remove(stubConfig)
+	defer os.Remove(stubFile)
+	consul := stubConsul(map[string]string{"lock_key": "leader"})
+
+	// Nothing is written when told to stop before the lock is acquired
+	locker := newFakeLocker()
+	stopCh := make(chan struct{})
+	close(stopCh)
+	assert.Nil(t, GovernWithLock([]string{stubConfig}, "", consul, locker, FAIL_FAST, stopCh))
+	_, err := os.Stat(stubFile)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 0, locker.Unlocks())
+
+	locker.grantCh <- make(chan struct{})
+	assert.Nil(t, GovernWithLock([]string{stubConfig}, "", consul, locker, FAIL_FAST, make(chan struct{})))
+	contents, err := ioutil.ReadFile(stubFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "leader", string(contents))
+	assert.Equal(t, 1, locker.Unlocks())
+}
+
+func TestWatchWithLock(t *testing.T) {
+
+	stubConfig, stubFile := writeLockConfig()
+	defer os.Remove(stubConfig)
+	defer os.Remove(stubFile)
+	consul := stubConsul(map[string]string{"lock_key": "leader"})
+	locker := newFakeLocker()
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		assert.Nil(t, WatchWithLock([]string{stubConfig}, "", consul, locker, stopCh))
+		close(doneCh)
+	}()
+
+	// Standing by, nothing is written
+	time.Sleep(50 * time.Millisecond)
+	_, err := os.Stat(stubFile)
+	assert.True(t, os.IsNotExist(err))
+
+	leaderCh := make(chan struct{})
+	locker.grantCh <- leaderCh
+	assert.Equal(t, "leader", waitForContents(stubFile, "leader"))
+
+	// Once the lock is lost, files are left to the new leader
+	close(leaderCh)
+	waitForUnlocks(t, locker, 1)
+	os.Remove(stubFile)
+	time.Sleep(50 * time.Millisecond)
+	_, err = os.Stat(stubFile)
+	assert.True(t, os.IsNotExist(err))
+
+	// And taken over again when the lock comes back
+	locker.grantCh <- make(chan struct{})
+	assert.Equal(t, "leader", waitForContents(stubFile, "leader"))
+
+	close(stopCh)
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("WatchWithLock did not stop after being signalled")
+	}
+	assert.Equal(t, 2, locker.Unlocks())
+}
@@ -0,0 +1,95 @@
+// optional.go
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+const (
+	// Write nothing, and leave whatever is on disk alone
+	OPTIONAL_SKIP string = "skip"
+	// Keep the file already on disk, which must exist
+	OPTIONAL_LEAVE string = "leave"
+	// Remove the file, as the key is gone
+	OPTIONAL_DELETE string = "delete"
+)
+
+func validOptional(optional string) bool {
+	switch optional {
+	case "", OPTIONAL_SKIP, OPTIONAL_LEAVE, OPTIONAL_DELETE:
+		return true
+	}
+	return false
+}
+
+// DefaultContent is the content written when the key of the entry does not
+// exist, or nil if the entry has no default.
+func (entry ConfigEntry) DefaultContent() (*string, error) {
+
+	if entry.Default != nil {
+		return entry.Default, nil
+	}
+	if entry.DefaultFile == "" {
+		return nil, nil
+	}
+
+	contents, err := ioutil.ReadFile(entry.DefaultFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the default file: %s", err)
+	}
+	content := string(contents)
+	return &content, nil
+}
+
+// missingKey decides what an optional entry does without its key. It returns
+// whether the files of the entry should be removed, or the error if the entry
+// cannot do without its key.
+func (entry ConfigEntry) missingKey(err error) (bool, error) {
+
+	if _, missing := err.(*KeyNotFoundError); !missing {
+		return false, err
+	}
+
+	switch entry.Optional {
+	case OPTIONAL_SKIP:
+		log.Println("Key does not exist, skipping:", entry.Key)
+		return false, nil
+
+	case OPTIONAL_LEAVE:
+		for _, target := range entry.Targets() {
+			if _, statErr := os.Stat(target.Destination); statErr != nil {
+				return false, fmt.Errorf("%s, and there is no file to leave at %s", err, target.Destination)
+			}
+		}
+		log.Println("Key does not exist, leaving the existing files:", entry.Key)
+		return false, nil
+
+	case OPTIONAL_DELETE:
+		log.Println("Key does not exist, removing its files:", entry.Key)
+		return true, nil
+	}
+
+	return false, err
+}
+
+// RemoveFiles removes the file at every destination of the entry, and
+// returns the files that were there.
+func (entry ConfigEntry) RemoveFiles() ([]string, error) {
+
+	removed := []string{}
+	for _, target := range entry.Targets() {
+		err := os.Remove(target.Destination)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		log.Println("Removed config file:", target.Destination)
+		removed = append(removed, target.Destination)
+	}
+	return removed, nil
+}
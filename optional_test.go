@@ -0,0 +1,97 @@
+// optional_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestValidateOptional(t *testing.T) {
+
+	defaultValue := "default"
+	invalid := []ConfigEntry{
+		{Key: "a", Destination: "a.conf", Optional: "maybe"},
+		{Key: "a", Destination: "a.conf", Optional: OPTIONAL_SKIP, Default: &defaultValue},
+		{Key: "a", Destination: "a.conf", Optional: OPTIONAL_SKIP, Tree: true},
+		{Key: "a", Destination: "a.conf", Default: &defaultValue, DefaultFile: "a.default"},
+		{Template: "a.tmpl", Destination: "a.conf", DefaultFile: "a.default"},
+	}
+	for _, entry := range invalid {
+		assert.NotNil(t, entry.Validate())
+	}
+
+	for _, optional := range []string{OPTIONAL_SKIP, OPTIONAL_LEAVE, OPTIONAL_DELETE} {
+		assert.Nil(t, ConfigEntry{Key: "a", Destination: "a.conf", Optional: optional}.Validate())
+	}
+	assert.Nil(t, ConfigEntry{Key: "a", Destination: "a.conf", DefaultFile: "a.default"}.Validate())
+}
+
+func TestDefaultFile(t *testing.T) {
+
+	consul := stubConsul(map[string]string{})
+
+	defaultFile := "workers.default"
+	err := ioutil.WriteFile(defaultFile, []byte("workers 1;"), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(defaultFile)
+
+	files, err := FetchEntry(ConfigEntry{Key: "workers", Destination: "workers.conf", DefaultFile: defaultFile}, consul)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"workers.conf": "workers 1;"}, files)
+
+	// A default that cannot be read fails the entry
+	_, err = FetchEntry(ConfigEntry{Key: "workers", Destination: "workers.conf", DefaultFile: "no-such.default"}, consul)
+	assert.NotNil(t, err)
+}
+
+func TestGovernOptional(t *testing.T) {
+
+	consul := stubConsul(map[string]string{})
+
+	// Only the files that should survive are there to begin with
+	for _, fileName := range []string{"leave.conf", "delete.conf"} {
+		if err := ioutil.WriteFile(fileName, []byte("existing"), 0644); err != nil {
+			panic(err)
+		}
+		defer os.Remove(fileName)
+	}
+	defer os.Remove("skip.conf")
+	defer os.Remove("deleted.marker")
+
+	stubConfig := "governor.conf"
+	stubContent := `{
+  "skip": {"destination": "skip.conf", "optional": "skip"},
+  "leave": {"destination": "leave.conf", "optional": "leave"},
+  "delete": {"destination": "delete.conf", "optional": "delete", "command": "touch deleted.marker"}
+}`
+	err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	assert.Nil(t, Govern([]string{stubConfig}, "", consul, FAIL_FAST))
+
+	_, err = os.Stat("skip.conf")
+	assert.True(t, os.IsNotExist(err))
+
+	contents, err := ioutil.ReadFile("leave.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, "existing", string(contents))
+
+	// Removing a file counts as a change, so its command runs
+	_, err = os.Stat("delete.conf")
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat("deleted.marker")
+	assert.Nil(t, err)
+
+	// There has to be a file to leave
+	os.Remove("leave.conf")
+	err = Govern([]string{stubConfig}, "", consul, FAIL_FAST)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no file to leave")
+}
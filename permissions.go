@@ -0,0 +1,134 @@
+// permissions.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"regexp"
+	"strconv"
+)
+
+// FilePermissions are applied to a written file, and to any folders that
+// had to be made for it. An id of -1 leaves the owner or group unchanged.
+type FilePermissions struct {
+	Mode    os.FileMode
+	DirMode os.FileMode
+	Uid     int
+	Gid     int
+}
+
+var DefaultPermissions = FilePermissions{
+	Mode:    FILE_MODE,
+	DirMode: FOLDER_MODE,
+	Uid:     -1,
+	Gid:     -1,
+}
+
+// secretPattern matches keys and paths that probably hold something secret
+var secretPattern = regexp.MustCompile(`(?i)(secret|password|passwd|token|private|credential|\.key$|\.pem$|_key$|-key$|/key$|^key$)`)
+
+func permissionsFor(permissions map[string]FilePermissions, filePath string) FilePermissions {
+	if filePermissions, ok := permissions[filePath]; ok {
+		return filePermissions
+	}
+	return DefaultPermissions
+}
+
+func parseMode(mode string, defaultMode os.FileMode) (os.FileMode, error) {
+
+	if mode == "" {
+		return defaultMode, nil
+	}
+
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || parsed > 0777 {
+		return 0, fmt.Errorf("Invalid mode %q, expected an octal value such as 0640", mode)
+	}
+	return os.FileMode(parsed), nil
+}
+
+func lookupId(name string, lookup func(string) (string, error)) (int, error) {
+
+	if name == "" {
+		return -1, nil
+	}
+
+	// Numeric ids do not need to exist on this machine
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// Permissions resolves the mode, owner and group of the entry.
+func (entry ConfigEntry) Permissions() (FilePermissions, error) {
+
+	mode, err := parseMode(entry.Mode, FILE_MODE)
+	if err != nil {
+		return FilePermissions{}, err
+	}
+
+	dirMode, err := parseMode(entry.DirMode, FOLDER_MODE)
+	if err != nil {
+		return FilePermissions{}, err
+	}
+
+	uid, err := lookupId(entry.Owner, func(name string) (string, error) {
+		owner, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return owner.Uid, nil
+	})
+	if err != nil {
+		return FilePermissions{}, err
+	}
+
+	gid, err := lookupId(entry.Group, func(name string) (string, error) {
+		group, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return group.Gid, nil
+	})
+	if err != nil {
+		return FilePermissions{}, err
+	}
+
+	return FilePermissions{Mode: mode, DirMode: dirMode, Uid: uid, Gid: gid}, nil
+}
+
+// warnIfExposed logs a warning when something that looks secret is going to
+// be readable by everyone.
+func warnIfExposed(name string, entry ConfigEntry, permissions FilePermissions) bool {
+
+	if permissions.Mode&0004 == 0 {
+		return false
+	}
+	if !secretPattern.MatchString(name) && !secretPattern.MatchString(entry.Destination) {
+		return false
+	}
+
+	log.Printf("Warning: %s looks like a secret, but %s will be world-readable with mode %#o\n",
+		name, entry.Destination, permissions.Mode)
+	return true
+}
+
+// applyPermissions sets the mode and owner of a file or folder.
+func applyPermissions(filePath string, mode os.FileMode, permissions FilePermissions) error {
+
+	if err := os.Chmod(filePath, mode); err != nil {
+		return err
+	}
+	if permissions.Uid != -1 || permissions.Gid != -1 {
+		return os.Chown(filePath, permissions.Uid, permissions.Gid)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// permissions_test.go
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestEntryPermissions(t *testing.T) {
+
+	// Nothing configured gives the secure defaults
+	permissions, err := ConfigEntry{}.Permissions()
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultPermissions, permissions)
+	assert.Equal(t, os.FileMode(0640), permissions.Mode)
+	assert.Equal(t, os.FileMode(0750), permissions.DirMode)
+
+	permissions, err = ConfigEntry{Mode: "0600", DirMode: "700", Owner: "1000", Group: "root"}.Permissions()
+	assert.Nil(t, err)
+	assert.Equal(t, FilePermissions{Mode: 0600, DirMode: 0700, Uid: 1000, Gid: 0}, permissions)
+
+	_, err = ConfigEntry{Mode: "rw-r-----"}.Permissions()
+	assert.NotNil(t, err)
+
+	_, err = ConfigEntry{Mode: "01777"}.Permissions()
+	assert.NotNil(t, err)
+
+	_, err = ConfigEntry{Owner: "no-such-user-governor"}.Permissions()
+	assert.NotNil(t, err)
+}
+
+func TestWarnIfExposed(t *testing.T) {
+
+	readable := FilePermissions{Mode: 0644}
+	private := FilePermissions{Mode: 0640}
+
+	assert.True(t, warnIfExposed("ssl_key", ConfigEntry{Destination: "/etc/ssl/site.crt"}, readable))
+	assert.True(t, warnIfExposed("site", ConfigEntry{Destination: "/etc/ssl/site.pem"}, readable))
+	assert.True(t, warnIfExposed("DB_PASSWORD", ConfigEntry{Destination: "db.conf"}, readable))
+	assert.False(t, warnIfExposed("ssl_key", ConfigEntry{Destination: "/etc/ssl/site.key"}, private))
+	assert.False(t, warnIfExposed("nginx", ConfigEntry{Destination: "/etc/nginx/nginx.conf"}, readable))
+}
+
+func TestGovernPermissions(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"ssl_key": "secret",
+	})
+
+	directory := "secure"
+	destination := filepath.Join(directory, "nested", "site.key")
+	defer os.RemoveAll(directory)
+
+	stubConfig := "governor.conf"
+	stubContent := fmt.Sprintf(`{"ssl_key": {
+		"destination": "%s",
+		"mode": "0600",
+		"dir_mode": "0700",
+		"owner": "%d",
+		"group": "%d"
+	}}`, destination, os.Getuid(), os.Getgid())
+	err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	assert.Nil(t, Govern([]string{stubConfig}, "", consul, FAIL_FAST))
+
+	info, err := os.Stat(destination)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.Equal(t, uint32(os.Getuid()), info.Sys().(*syscall.Stat_t).Uid)
+
+	// Every folder that had to be made gets the folder mode
+	for _, folder := range []string{directory, filepath.Dir(destination)} {
+		info, err = os.Stat(folder)
+		assert.Nil(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+}
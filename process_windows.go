@@ -0,0 +1,34 @@
+// process_windows.go
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// SHELL runs the commands of entries
+var SHELL = []string{"cmd", "/C"}
+
+// forwardedSignals are passed on to the child rather than handled by governor
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+var signalNames = map[string]syscall.Signal{
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"TERM": syscall.SIGTERM,
+}
+
+// newProcessGroup starts cmd in a process group of its own, so that the
+// console only reaches it through us.
+func newProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// killProcessGroup kills the process. Windows has no way to reach anything it
+// started.
+func killProcessGroup(process *os.Process) error {
+	return process.Kill()
+}
@@ -0,0 +1,226 @@
+// push.go
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KVWriter is what pushing files back asks of Consul, on top of reading.
+type KVWriter interface {
+	Put(pair *api.KVPair, options *api.WriteOptions) (*api.WriteMeta, error)
+	CAS(pair *api.KVPair, options *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+func (client *Client) Put(pair *api.KVPair, options *api.WriteOptions) (*api.WriteMeta, error) {
+	return client.api.KV().Put(pair, options)
+}
+
+func (client *Client) CAS(pair *api.KVPair, options *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	return client.api.KV().CAS(pair, options)
+}
+
+// PushOptions decide how local files are pushed to Consul.
+type PushOptions struct {
+	// Only show what would be pushed
+	DryRun bool
+	// Overwrite keys even if they were modified since they were read
+	Force bool
+	// Asked before anything is pushed, unless nil
+	Confirm func(keys int) bool
+}
+
+// PushError collects every key that could not be pushed.
+type PushError struct {
+	Errors []error
+}
+
+func (err *PushError) Error() string {
+
+	messages := []string{}
+	for _, keyErr := range err.Errors {
+		messages = append(messages, keyErr.Error())
+	}
+	return fmt.Sprintf("%d key(s) could not be pushed: %s",
+		len(err.Errors), strings.Join(messages, "; "))
+}
+
+// pushedKey is a local file, and the key it is pushed to.
+type pushedKey struct {
+	name     string
+	key      string
+	filePath string
+	contents string
+	index    uint64
+}
+
+// encodeValue reverses the entry's decoder, where that is possible.
+func (entry ConfigEntry) encodeValue(contents string) (string, error) {
+
+	switch strings.TrimSpace(entry.Decode) {
+	case "", "none":
+		return contents, nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(contents)), nil
+	}
+	return "", fmt.Errorf("Its decoder %q cannot be reversed", entry.Decode)
+}
+
+// PushKeys reads the files of an entry, and the keys they belong to. Entries
+// with several destinations are pushed from the first of them.
+func (entry ConfigEntry) PushKeys(name string) ([]pushedKey, error) {
+
+	if entry.Template != "" {
+		return nil, fmt.Errorf("A template cannot be pushed")
+	}
+	destination := entry.Targets()[0].Destination
+
+	files := make(map[string]string)
+	if entry.Tree {
+		err := filepath.Walk(destination, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			relative, err := filepath.Rel(destination, filePath)
+			if err != nil {
+				return err
+			}
+			files[strings.TrimSuffix(entry.Key, "/")+"/"+filepath.ToSlash(relative)] = filePath
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		files[entry.Key] = destination
+	}
+
+	keys := []pushedKey{}
+	for key, filePath := range files {
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		value, err := entry.encodeValue(string(contents))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, pushedKey{name: name, key: key, filePath: filePath, contents: value})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].key < keys[j].key })
+	return keys, nil
+}
+
+// Push writes the local files of every entry back to their keys in Consul,
+// showing what changes first. A key is only overwritten if it was not
+// modified since it was read, unless forced.
+func Push(configFiles []string, format string, client ConsulClient, writer KVWriter, options PushOptions, out io.Writer) error {
+
+	// Parse the config file
+	configMap, err := GetConfigFromFiles(configFiles, format)
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for name := range configMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Compare every file with its key. Templates have no key to push to.
+	pending := []pushedKey{}
+	errs := []error{}
+	for _, name := range names {
+		if configMap[name].Template != "" {
+			log.Println("Skipping template", name)
+			continue
+		}
+		keys, err := configMap[name].PushKeys(name)
+		if err != nil {
+			log.Println("Could not read", name+":", err)
+			errs = append(errs, &EntryError{Name: name, Err: err})
+			continue
+		}
+
+		for _, pushed := range keys {
+			pair, _, err := client.Get(pushed.key, nil)
+			if err != nil {
+				return fmt.Errorf("Error raised when attempting to read key from consul: %s", err)
+			}
+
+			status, fromName, existing := STATUS_CREATE, os.DevNull, ""
+			if pair != nil {
+				status, fromName, existing = STATUS_UPDATE, pushed.key, string(pair.Value)
+				pushed.index = pair.ModifyIndex
+			}
+			if pair != nil && existing == pushed.contents {
+				fmt.Fprintf(out, "%s %s\n", STATUS_UNCHANGED, pushed.key)
+				continue
+			}
+
+			fmt.Fprintf(out, "%s %s\n", status, pushed.key)
+			mask := secretMask(name, pushed.filePath)
+			fmt.Fprint(out, UnifiedDiff(fromName, pushed.key, existing, pushed.contents, mask))
+			pending = append(pending, pushed)
+		}
+	}
+
+	if len(pending) == 0 {
+		log.Println("Nothing to push")
+		return pushError(errs)
+	}
+	if options.DryRun {
+		return pushError(errs)
+	}
+	if options.Confirm != nil && !options.Confirm(len(pending)) {
+		log.Println("Nothing was pushed")
+		return pushError(errs)
+	}
+
+	for _, pushed := range pending {
+		pair := &api.KVPair{Key: pushed.key, Value: []byte(pushed.contents), ModifyIndex: pushed.index}
+		if options.Force {
+			_, err = writer.Put(pair, nil)
+		} else {
+			var written bool
+			written, _, err = writer.CAS(pair, nil)
+			if err == nil && !written {
+				err = fmt.Errorf("Key was modified since it was read, not overwriting it")
+			}
+		}
+		if err != nil {
+			errs = append(errs, &EntryError{Name: pushed.key, Err: err})
+			continue
+		}
+		log.Println("Pushed", pushed.filePath, "to", pushed.key)
+	}
+	return pushError(errs)
+}
+
+// pushError reports the keys that could not be pushed, if there are any.
+func pushError(errs []error) error {
+	if len(errs) > 0 {
+		return &PushError{Errors: errs}
+	}
+	return nil
+}
+
+// confirmPush asks on out whether to push, reading the answer from in.
+func confirmPush(in io.Reader, out io.Writer) func(int) bool {
+	return func(keys int) bool {
+		fmt.Fprintf(out, "Push %d key(s) to Consul? [y/N] ", keys)
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "y" || answer == "yes"
+	}
+}
@@ -0,0 +1,197 @@
+// push_test.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func (consul *fakeConsul) Put(pair *api.KVPair, options *api.WriteOptions) (*api.WriteMeta, error) {
+
+	consul.mu.Lock()
+	defer consul.mu.Unlock()
+	consul.values[pair.Key] = string(pair.Value)
+	consul.indexes[pair.Key] = consul.modifyIndex(pair.Key) + 1
+	return &api.WriteMeta{}, nil
+}
+
+func (consul *fakeConsul) CAS(pair *api.KVPair, options *api.WriteOptions) (bool, *api.WriteMeta, error) {
+
+	consul.mu.Lock()
+	_, exists := consul.values[pair.Key]
+	current := uint64(0)
+	if exists {
+		current = consul.modifyIndex(pair.Key)
+	}
+	consul.mu.Unlock()
+
+	if pair.ModifyIndex != current {
+		return false, &api.WriteMeta{}, nil
+	}
+	_, err := consul.Put(pair, options)
+	return err == nil, &api.WriteMeta{}, err
+}
+
+// writePushFiles lays out the local files of a config, and returns the config.
+func writePushFiles(t *testing.T, directory string) string {
+
+	files := map[string]string{
+		"nginx.conf":      "worker_processes 4;\n",
+		"app.conf":        "debug = false\npassword = new\n",
+		"site.key":        "keystore",
+		"tree/one.conf":   "one\n",
+		"tree/sub/two.kv": "two\n",
+	}
+	for name, contents := range files {
+		filePath := filepath.Join(directory, name)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(filePath), 0755))
+		assert.Nil(t, ioutil.WriteFile(filePath, []byte(contents), 0644))
+	}
+
+	configFile := filepath.Join(directory, "govern.conf")
+	config := fmt.Sprintf(`{"version": 2, "entries": [
+  {"key": "nginx", "destination": "%[1]s/nginx.conf"},
+  {"key": "app", "destinations": ["%[1]s/app.conf", "%[1]s/other.conf"]},
+  {"key": "ssl_key", "destination": "%[1]s/site.key", "decode": "base64"},
+  {"key": "settings", "destination": "%[1]s/settings.yml", "decode": "json:app | yaml"},
+  {"key": "apps/", "destination": "%[1]s/tree", "tree": true},
+  {"name": "upstreams", "template": "%[1]s/upstreams.tmpl", "destination": "%[1]s/upstreams.conf"}
+]}`, directory)
+	assert.Nil(t, ioutil.WriteFile(configFile, []byte(config), 0644))
+	return configFile
+}
+
+func TestPushKeys(t *testing.T) {
+
+	directory, _ := ioutil.TempDir("", "push")
+	defer os.RemoveAll(directory)
+	writePushFiles(t, directory)
+
+	keys, err := ConfigEntry{Key: "apps/", Tree: true, Destination: filepath.Join(directory, "tree")}.PushKeys("apps")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(keys))
+	assert.Equal(t, "apps/one.conf", keys[0].key)
+	assert.Equal(t, "apps/sub/two.kv", keys[1].key)
+
+	keys, err = ConfigEntry{Key: "ssl_key", Destination: filepath.Join(directory, "site.key"), Decode: "base64"}.PushKeys("ssl_key")
+	assert.Nil(t, err)
+	assert.Equal(t, "a2V5c3RvcmU=", keys[0].contents)
+
+	// Decoders that lose information cannot be pushed back
+	_, err = ConfigEntry{Key: "settings", Destination: "settings.yml", Decode: "json:app"}.PushKeys("settings")
+	assert.NotNil(t, err)
+	_, err = ConfigEntry{Template: "upstreams.tmpl", Destination: "upstreams.conf"}.PushKeys("upstreams")
+	assert.NotNil(t, err)
+}
+
+func TestPush(t *testing.T) {
+
+	directory, _ := ioutil.TempDir("", "push")
+	defer os.RemoveAll(directory)
+	configFile := writePushFiles(t, directory)
+
+	consul := stubConsul(map[string]string{
+		"nginx":         "worker_processes 4;\n",
+		"app":           "debug = false\npassword = old\n",
+		"apps/one.conf": "ONE\n",
+	})
+
+	// A dry run only shows the changes. The settings cannot be pushed back,
+	// which fails the run but not the other keys.
+	var out bytes.Buffer
+	err := Push([]string{configFile}, "", consul, consul, PushOptions{DryRun: true}, &out)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "1 key(s) could not be pushed: settings:"))
+	expected := `update app
+--- app
++++ app
+@@ -1,2 +1,2 @@
+ debug = false
+-password = ********
++password = ********
+update apps/one.conf
+--- apps/one.conf
++++ apps/one.conf
+@@ -1 +1 @@
+-ONE
++one
+create apps/sub/two.kv
+--- /dev/null
++++ apps/sub/two.kv
+@@ -0,0 +1 @@
++two
+unchanged nginx
+create ssl_key
+--- /dev/null
++++ ssl_key
+@@ -0,0 +1 @@
++********
+`
+	assert.Equal(t, expected, out.String())
+	assert.Equal(t, "ONE\n", consul.values["apps/one.conf"])
+
+	// Nothing is pushed unless confirmed
+	refuse := func(keys int) bool {
+		assert.Equal(t, 4, keys)
+		return false
+	}
+	assert.NotNil(t, Push([]string{configFile}, "", consul, consul, PushOptions{Confirm: refuse}, &out))
+	assert.Equal(t, "ONE\n", consul.values["apps/one.conf"])
+
+	// A key modified after it was read is not overwritten
+	modify := func(keys int) bool {
+		consul.Put(&api.KVPair{Key: "app", Value: []byte("changed elsewhere")}, nil)
+		return true
+	}
+	err = Push([]string{configFile}, "", consul, consul, PushOptions{Confirm: modify}, &out)
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "2 key(s) could not be pushed: settings:"))
+	assert.True(t, strings.Contains(err.Error(), "; app: Key was modified"))
+	assert.Equal(t, "changed elsewhere", consul.values["app"])
+	assert.Equal(t, "one\n", consul.values["apps/one.conf"])
+	assert.Equal(t, "two\n", consul.values["apps/sub/two.kv"])
+	assert.Equal(t, "a2V5c3RvcmU=", consul.values["ssl_key"])
+
+	// Unless forced
+	err = Push([]string{configFile}, "", consul, consul, PushOptions{Force: true}, &out)
+	assert.Equal(t, 1, len(err.(*PushError).Errors))
+	assert.Equal(t, "debug = false\npassword = new\n", consul.values["app"])
+}
+
+func TestConfirmPush(t *testing.T) {
+
+	var out bytes.Buffer
+	assert.True(t, confirmPush(strings.NewReader("y\n"), &out)(2))
+	assert.Equal(t, "Push 2 key(s) to Consul? [y/N] ", out.String())
+	assert.True(t, confirmPush(strings.NewReader("YES\n"), &out)(2))
+	assert.False(t, confirmPush(strings.NewReader("\n"), &out)(2))
+	assert.False(t, confirmPush(strings.NewReader(""), &out)(2))
+}
+
+func TestPushMissingFile(t *testing.T) {
+
+	directory, _ := ioutil.TempDir("", "push")
+	defer os.RemoveAll(directory)
+
+	configFile := filepath.Join(directory, "govern.conf")
+	config := fmt.Sprintf(`{"version": 2, "entries": [
+  {"key": "missing", "destination": "%[1]s/missing.conf"},
+  {"name": "upstreams", "template": "%[1]s/upstreams.tmpl", "destination": "%[1]s/upstreams.conf"}
+]}`, directory)
+	assert.Nil(t, ioutil.WriteFile(configFile, []byte(config), 0644))
+
+	// Only the missing file is an error, templates are skipped
+	var out bytes.Buffer
+	err := Push([]string{configFile}, "", stubConsul(map[string]string{}), nil, PushOptions{}, &out)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(err.(*PushError).Errors))
+	assert.True(t, strings.HasPrefix(err.Error(), "1 key(s) could not be pushed: missing:"))
+	assert.Equal(t, EXIT_FAILED, ExitCode(err))
+}
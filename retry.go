@@ -0,0 +1,154 @@
+// retry.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	RETRY_ATTEMPTS    int           = 5
+	RETRY_BACKOFF     time.Duration = 500 * time.Millisecond
+	RETRY_MAX_BACKOFF time.Duration = 10 * time.Second
+	RETRY_DEADLINE    time.Duration = time.Minute
+)
+
+// RetryPolicy decides how long to keep trying a Consul call that failed
+// because Consul was unavailable.
+type RetryPolicy struct {
+	Attempts       int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Deadline       time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:       RETRY_ATTEMPTS,
+	InitialBackoff: RETRY_BACKOFF,
+	MaxBackoff:     RETRY_MAX_BACKOFF,
+	Deadline:       RETRY_DEADLINE,
+}
+
+// Backoff is how long to wait before the given retry, doubling each time.
+func (policy RetryPolicy) Backoff(retry int) time.Duration {
+
+	backoff := policy.InitialBackoff
+	for i := 1; i < retry && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	return backoff
+}
+
+// statusPattern finds the status code in the errors of the Consul API
+var statusPattern = regexp.MustCompile(`Unexpected response code: (\d+)`)
+
+// IsRetryable reports whether a call may succeed if it is tried again. Consul
+// being unreachable or failing is worth retrying, being refused is not.
+func IsRetryable(err error) bool {
+
+	if err == nil {
+		return false
+	}
+
+	if match := statusPattern.FindStringSubmatch(err.Error()); match != nil {
+		status, _ := strconv.Atoi(match[1])
+		return status >= 500 || status == 429
+	}
+
+	// A certificate that cannot be trusted will not be trusted next time either
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var verification *tls.CertificateVerificationError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &hostname) ||
+		errors.As(err, &invalid) || errors.As(err, &verification) {
+		return false
+	}
+
+	// Anything that went wrong on the way to Consul, such as a refused connection
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// RetryingClient retries the calls of another client while Consul is
+// unavailable.
+type RetryingClient struct {
+	client ConsulClient
+	policy RetryPolicy
+	sleep  func(time.Duration)
+}
+
+func NewRetryingClient(client ConsulClient, policy RetryPolicy) *RetryingClient {
+	return &RetryingClient{client: client, policy: policy, sleep: time.Sleep}
+}
+
+func (client *RetryingClient) retry(name string, call func() error) error {
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+
+		err := call()
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+		if attempt >= client.policy.Attempts {
+			return fmt.Errorf("Giving up on %s after %d attempt(s): %w", name, attempt, err)
+		}
+
+		// Never wait past the deadline
+		backoff := client.policy.Backoff(attempt)
+		remaining := client.policy.Deadline - time.Since(start)
+		if remaining <= 0 {
+			return fmt.Errorf("Giving up on %s after %s: %w", name, client.policy.Deadline, err)
+		}
+		if backoff > remaining {
+			backoff = remaining
+		}
+
+		log.Println("Consul is unavailable for", name, "retrying in", backoff, ":", err)
+		client.sleep(backoff)
+	}
+}
+
+func (client *RetryingClient) Get(key string, options *api.QueryOptions) (keyValue *api.KVPair, meta *api.QueryMeta, err error) {
+	err = client.retry("key "+key, func() error {
+		keyValue, meta, err = client.client.Get(key, options)
+		return err
+	})
+	return keyValue, meta, err
+}
+
+func (client *RetryingClient) List(prefix string, options *api.QueryOptions) (keyValues api.KVPairs, meta *api.QueryMeta, err error) {
+	err = client.retry("prefix "+prefix, func() error {
+		keyValues, meta, err = client.client.List(prefix, options)
+		return err
+	})
+	return keyValues, meta, err
+}
+
+func (client *RetryingClient) Service(service string, tag string, passingOnly bool, options *api.QueryOptions) (entries []*api.ServiceEntry, meta *api.QueryMeta, err error) {
+	err = client.retry("service "+service, func() error {
+		entries, meta, err = client.client.Service(service, tag, passingOnly, options)
+		return err
+	})
+	return entries, meta, err
+}
+
+func (client *RetryingClient) Services(options *api.QueryOptions) (services map[string][]string, meta *api.QueryMeta, err error) {
+	err = client.retry("services", func() error {
+		services, meta, err = client.client.Services(options)
+		return err
+	})
+	return services, meta, err
+}
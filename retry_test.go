@@ -0,0 +1,121 @@
+// retry_test.go
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+
+	refused := &url.Error{Op: "Get", URL: "http://127.0.0.1:8500", Err: errors.New("connection refused")}
+	assert.True(t, IsRetryable(refused))
+	assert.True(t, IsRetryable(errors.New("Unexpected response code: 500 (rpc error)")))
+	assert.True(t, IsRetryable(errors.New("Unexpected response code: 503")))
+
+	assert.False(t, IsRetryable(nil))
+	assert.False(t, IsRetryable(errors.New("Unexpected response code: 403 (ACL not found)")))
+	assert.False(t, IsRetryable(&KeyNotFoundError{Key: "missing"}))
+}
+
+func TestRetryBackoff(t *testing.T) {
+
+	policy := RetryPolicy{Attempts: 10, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second, Deadline: time.Minute}
+	assert.Equal(t, time.Second, policy.Backoff(1))
+	assert.Equal(t, 2*time.Second, policy.Backoff(2))
+	assert.Equal(t, 4*time.Second, policy.Backoff(3))
+	assert.Equal(t, 5*time.Second, policy.Backoff(4))
+	assert.Equal(t, 5*time.Second, policy.Backoff(20))
+}
+
+func TestRetryPolicyFromConfig(t *testing.T) {
+
+	policy, err := ConsulConfig{}.RetryPolicy()
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultRetryPolicy, policy)
+
+	policy, err = ConsulConfig{RetryAttempts: 3, RetryBackoff: "1s", RetryMaxBackoff: "4s", RetryDeadline: "30s"}.RetryPolicy()
+	assert.Nil(t, err)
+	assert.Equal(t, RetryPolicy{Attempts: 3, InitialBackoff: time.Second, MaxBackoff: 4 * time.Second, Deadline: 30 * time.Second}, policy)
+
+	_, err = ConsulConfig{RetryBackoff: "soon"}.RetryPolicy()
+	assert.NotNil(t, err)
+
+	_, err = ConsulConfig{RetryBackoff: "5s", RetryMaxBackoff: "1s"}.RetryPolicy()
+	assert.NotNil(t, err)
+}
+
+func TestRetryingClient(t *testing.T) {
+
+	// Consul fails a couple of times while it starts
+	failures := 2
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			w.WriteHeader(503)
+			return
+		}
+		valueBase64 := base64.StdEncoding.EncodeToString([]byte("ready"))
+		w.Header().Set("X-Consul-Index", "1")
+		fmt.Fprintf(w, `[{"CreateIndex": 1, "ModifyIndex": 1, "Key": "app", "Flags": 0, "Value": "%s"}]`, valueBase64)
+	}))
+	defer server.Close()
+
+	apiClient, err := NewClient(ConsulConfig{Address: server.URL}, nil)
+	assert.Nil(t, err)
+
+	slept := []time.Duration{}
+	client := NewRetryingClient(apiClient, RetryPolicy{Attempts: 5, InitialBackoff: time.Second, MaxBackoff: time.Minute, Deadline: time.Hour})
+	client.sleep = func(backoff time.Duration) { slept = append(slept, backoff) }
+
+	value, err := GetAttribute("app", client)
+	assert.Nil(t, err)
+	assert.Equal(t, "ready", value)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, slept)
+}
+
+func TestRetryingClientGivesUp(t *testing.T) {
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/v1/kv/forbidden" {
+			w.WriteHeader(403)
+			return
+		}
+		w.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	apiClient, err := NewClient(ConsulConfig{Address: server.URL}, nil)
+	assert.Nil(t, err)
+
+	client := NewRetryingClient(apiClient, RetryPolicy{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Deadline: time.Hour})
+	client.sleep = func(time.Duration) {}
+
+	// Failures of Consul are retried until we run out of attempts
+	_, err = GetAttribute("failing", client)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "after 3 attempt(s)")
+	assert.Equal(t, 3, requests)
+
+	// Being refused is not retried at all
+	requests = 0
+	_, err = GetAttribute("forbidden", client)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, requests)
+
+	// Nor is anything once the deadline has passed
+	requests = 0
+	client.policy = RetryPolicy{Attempts: 100, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond, Deadline: time.Nanosecond}
+	_, err = GetAttribute("failing", client)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, requests)
+}
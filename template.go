@@ -0,0 +1,323 @@
+// template.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// KeyPair is a single key under a prefix, as returned by the ls function.
+type KeyPair struct {
+	Key   string
+	Value string
+}
+
+// Service is a single instance of a service, as returned by the service function.
+type Service struct {
+	Node    string
+	Address string
+	ID      string
+	Name    string
+	Port    int
+	Tags    []string
+}
+
+// ServiceSummary is a service known to the catalog, as returned by the
+// services function.
+type ServiceSummary struct {
+	Name string
+	Tags []string
+}
+
+// templateQuery is a Consul query made while rendering, that can be repeated
+// as a blocking query to find out when its result changes.
+type templateQuery struct {
+	lastIndex uint64
+	wait      func(waitIndex uint64) (uint64, error)
+}
+
+// TemplateRenderer renders a template, remembering every query the template
+// made so that watch mode knows when to render it again.
+type TemplateRenderer struct {
+	client  ConsulClient
+	queries map[string]*templateQuery
+}
+
+func NewTemplateRenderer(client ConsulClient) *TemplateRenderer {
+	return &TemplateRenderer{
+		client:  client,
+		queries: make(map[string]*templateQuery),
+	}
+}
+
+func (renderer *TemplateRenderer) record(name string, lastIndex uint64, wait func(uint64) (uint64, error)) {
+	renderer.queries[name] = &templateQuery{lastIndex: lastIndex, wait: wait}
+}
+
+func (renderer *TemplateRenderer) getKey(key string) (*api.KVPair, error) {
+
+	client := renderer.client
+	keyValue, meta, err := client.Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	renderer.record("key:"+key, meta.LastIndex, func(waitIndex uint64) (uint64, error) {
+		_, meta, err := client.Get(key, &api.QueryOptions{WaitIndex: waitIndex, WaitTime: WATCH_WAIT_TIME})
+		if err != nil {
+			return 0, err
+		}
+		return meta.LastIndex, nil
+	})
+
+	return keyValue, nil
+}
+
+func (renderer *TemplateRenderer) key(key string) (string, error) {
+
+	keyValue, err := renderer.getKey(key)
+	if err != nil {
+		return "", err
+	}
+	if keyValue == nil {
+		return "", &KeyNotFoundError{Key: key}
+	}
+	return string(keyValue.Value), nil
+}
+
+func (renderer *TemplateRenderer) keyOrDefault(key string, defaultValue string) (string, error) {
+
+	keyValue, err := renderer.getKey(key)
+	if err != nil {
+		return "", err
+	}
+	if keyValue == nil {
+		return defaultValue, nil
+	}
+	return string(keyValue.Value), nil
+}
+
+func (renderer *TemplateRenderer) ls(prefix string) ([]KeyPair, error) {
+
+	client := renderer.client
+	keyValues, meta, err := client.List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	renderer.record("ls:"+prefix, meta.LastIndex, func(waitIndex uint64) (uint64, error) {
+		_, meta, err := client.List(prefix, &api.QueryOptions{WaitIndex: waitIndex, WaitTime: WATCH_WAIT_TIME})
+		if err != nil {
+			return 0, err
+		}
+		return meta.LastIndex, nil
+	})
+
+	// Only keep the keys directly under the prefix, relative to it
+	pairs := []KeyPair{}
+	folder := strings.TrimSuffix(prefix, "/") + "/"
+	for _, keyValue := range keyValues {
+		if !strings.HasPrefix(keyValue.Key, folder) {
+			continue
+		}
+		name := strings.TrimPrefix(keyValue.Key, folder)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		pairs = append(pairs, KeyPair{Key: name, Value: string(keyValue.Value)})
+	}
+	return pairs, nil
+}
+
+func (renderer *TemplateRenderer) service(name string, filters ...string) ([]Service, error) {
+
+	// A tag can be given as "tag.name"
+	tag := ""
+	if parts := strings.SplitN(name, ".", 2); len(parts) == 2 {
+		tag, name = parts[0], parts[1]
+	}
+
+	// Only healthy instances are returned unless asked otherwise
+	passingOnly := true
+	for _, filter := range filters {
+		switch filter {
+		case "passing":
+			passingOnly = true
+		case "any":
+			passingOnly = false
+		default:
+			return nil, fmt.Errorf("Unknown health filter for service %s: %s", name, filter)
+		}
+	}
+
+	client := renderer.client
+	entries, meta, err := client.Service(name, tag, passingOnly, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	renderer.record(fmt.Sprintf("service:%s:%s:%t", tag, name, passingOnly), meta.LastIndex, func(waitIndex uint64) (uint64, error) {
+		_, meta, err := client.Service(name, tag, passingOnly, &api.QueryOptions{WaitIndex: waitIndex, WaitTime: WATCH_WAIT_TIME})
+		if err != nil {
+			return 0, err
+		}
+		return meta.LastIndex, nil
+	})
+
+	services := []Service{}
+	for _, entry := range entries {
+
+		// The service address falls back to the address of its node
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+
+		services = append(services, Service{
+			Node:    entry.Node.Node,
+			Address: address,
+			ID:      entry.Service.ID,
+			Name:    entry.Service.Service,
+			Port:    entry.Service.Port,
+			Tags:    entry.Service.Tags,
+		})
+	}
+
+	// Keep the output stable so that files are not rewritten needlessly
+	sort.Sort(servicesByNode(services))
+	return services, nil
+}
+
+type servicesByNode []Service
+
+func (services servicesByNode) Len() int      { return len(services) }
+func (services servicesByNode) Swap(i, j int) { services[i], services[j] = services[j], services[i] }
+func (services servicesByNode) Less(i, j int) bool {
+	if services[i].Node != services[j].Node {
+		return services[i].Node < services[j].Node
+	}
+	return services[i].ID < services[j].ID
+}
+
+func (renderer *TemplateRenderer) services() ([]ServiceSummary, error) {
+
+	client := renderer.client
+	catalogServices, meta, err := client.Services(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	renderer.record("services", meta.LastIndex, func(waitIndex uint64) (uint64, error) {
+		_, meta, err := client.Services(&api.QueryOptions{WaitIndex: waitIndex, WaitTime: WATCH_WAIT_TIME})
+		if err != nil {
+			return 0, err
+		}
+		return meta.LastIndex, nil
+	})
+
+	names := []string{}
+	for name := range catalogServices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	summaries := []ServiceSummary{}
+	for _, name := range names {
+		summaries = append(summaries, ServiceSummary{Name: name, Tags: catalogServices[name]})
+	}
+	return summaries, nil
+}
+
+func (renderer *TemplateRenderer) funcs() template.FuncMap {
+	return template.FuncMap{
+		"key":          renderer.key,
+		"keyOrDefault": renderer.keyOrDefault,
+		"ls":           renderer.ls,
+		"service":      renderer.service,
+		"services":     renderer.services,
+		"env":          os.Getenv,
+	}
+}
+
+// parse reads the template file, without running any of its functions.
+func (renderer *TemplateRenderer) parse(templateFile string) (*template.Template, error) {
+	return template.New(filepath.Base(templateFile)).
+		Funcs(renderer.funcs()).
+		Option("missingkey=error").
+		ParseFiles(templateFile)
+}
+
+// Render executes the template file, resolving its functions through Consul.
+func (renderer *TemplateRenderer) Render(templateFile string) (string, error) {
+
+	// Forget the queries of any previous render
+	renderer.queries = make(map[string]*templateQuery)
+
+	tmpl, err := renderer.parse(templateFile)
+	if err != nil {
+		return "", err
+	}
+
+	var output bytes.Buffer
+	if err := tmpl.Execute(&output, nil); err != nil {
+		return "", err
+	}
+	return output.String(), nil
+}
+
+// WaitForChange blocks until the result of any query made by the last render
+// changes. It returns false if it was stopped first.
+func (renderer *TemplateRenderer) WaitForChange(stopCh <-chan struct{}) bool {
+
+	changeCh := make(chan struct{}, len(renderer.queries))
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+
+	for name, query := range renderer.queries {
+		go func(name string, query *templateQuery) {
+			lastIndex := query.lastIndex
+			for {
+				index, err := query.wait(lastIndex)
+
+				select {
+				case <-doneCh:
+					return
+				default:
+				}
+
+				if err != nil {
+					log.Println("Error raised when watching", name, "retrying:", err)
+					time.Sleep(WATCH_RETRY_WAIT)
+					continue
+				}
+
+				// Only a moving index means the result may have changed
+				if index != lastIndex {
+					changeCh <- struct{}{}
+					return
+				}
+			}
+		}(name, query)
+	}
+
+	select {
+	case <-stopCh:
+		return false
+	case <-changeCh:
+		return true
+	}
+}
+
+func RenderTemplate(templateFile string, client ConsulClient) (string, error) {
+
+	log.Println("Rendering template: ", templateFile)
+	return NewTemplateRenderer(client).Render(templateFile)
+}
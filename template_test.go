@@ -0,0 +1,247 @@
+// template_test.go
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"nginx/port":                "8080",
+		"nginx/upstreams/one":       "10.0.0.1",
+		"nginx/upstreams/two":       "10.0.0.2",
+		"nginx/upstreams/sub/three": "10.0.0.3",
+	})
+
+	os.Setenv("GOVERNOR_TEST_NAME", "example.com")
+	defer os.Unsetenv("GOVERNOR_TEST_NAME")
+
+	// Make a stub template using every function
+	stubTemplate := "nginx.conf.tmpl"
+	stubContent := `listen {{ key "nginx/port" }};
+server_name {{ env "GOVERNOR_TEST_NAME" }};
+worker_processes {{ keyOrDefault "nginx/workers" "4" }};
+{{ range ls "nginx/upstreams" }}server {{ .Key }} {{ .Value }};
+{{ end }}`
+	err := ioutil.WriteFile(stubTemplate, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubTemplate)
+
+	rendered, err := RenderTemplate(stubTemplate, consul)
+	assert.Nil(t, err)
+	assert.Equal(t, `listen 8080;
+server_name example.com;
+worker_processes 4;
+server one 10.0.0.1;
+server two 10.0.0.2;
+`, rendered)
+}
+
+func TestRenderTemplateListPrefix(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"app":         "self",
+		"app/a":       "1",
+		"application": "x",
+	})
+
+	stubTemplate := "ls.tmpl"
+	err := ioutil.WriteFile(stubTemplate, []byte(`{{ range ls "app" }}{{ .Key }}={{ .Value }};{{ end }}`), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubTemplate)
+
+	rendered, err := RenderTemplate(stubTemplate, consul)
+	assert.Nil(t, err)
+	assert.Equal(t, "a=1;", rendered)
+}
+
+func TestRenderTemplateMissingKey(t *testing.T) {
+
+	consul := stubConsul(map[string]string{})
+
+	stubTemplate := "missing.tmpl"
+	err := ioutil.WriteFile(stubTemplate, []byte(`{{ key "does/not/exist" }}`), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubTemplate)
+
+	_, err = RenderTemplate(stubTemplate, consul)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "does/not/exist")
+
+	// The template only wraps the error of the key
+	var keyErr *KeyNotFoundError
+	assert.True(t, errors.As(err, &keyErr))
+	assert.Equal(t, "does/not/exist", keyErr.Key)
+}
+
+func TestGovernTemplate(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"nginx/port": "8080",
+	})
+
+	stubTemplate := "govern.tmpl"
+	err := ioutil.WriteFile(stubTemplate, []byte(`listen {{ key "nginx/port" }};`), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubTemplate)
+
+	stubConfig := "governor.conf"
+	stubContent := `{"nginx": {"template": "govern.tmpl", "destination": "rendered.conf"}}`
+	err = ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	err = Govern([]string{stubConfig}, "", consul, FAIL_FAST)
+	assert.Nil(t, err)
+	defer os.Remove("rendered.conf")
+
+	contents, err := ioutil.ReadFile("rendered.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, "listen 8080;", string(contents))
+}
+
+func TestTemplateWaitForChange(t *testing.T) {
+
+	// The key moves to a new index as soon as it is watched
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("index") == "" {
+			w.Header().Set("X-Consul-Index", "1")
+		} else {
+			w.Header().Set("X-Consul-Index", "2")
+		}
+		w.WriteHeader(200)
+		fmt.Fprintln(w, `[{"Key": "nginx/port", "ModifyIndex": 1, "Value": "ODA4MA=="}]`)
+	}))
+	defer server.Close()
+
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return url.Parse(server.URL)
+		},
+	}
+	httpClient := &http.Client{Transport: transport}
+	client := newTestClient(httpClient)
+
+	stubTemplate := "watch.tmpl"
+	err := ioutil.WriteFile(stubTemplate, []byte(`{{ key "nginx/port" }}`), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubTemplate)
+
+	renderer := NewTemplateRenderer(client)
+	rendered, err := renderer.Render(stubTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, "8080", rendered)
+
+	assert.True(t, renderer.WaitForChange(make(chan struct{})))
+
+	// A template without any queries only returns when stopped
+	stopCh := make(chan struct{})
+	close(stopCh)
+	assert.False(t, NewTemplateRenderer(client).WaitForChange(stopCh))
+}
+
+func TestRenderServiceTemplate(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		w.WriteHeader(200)
+
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			fmt.Fprintln(w, `{"web": ["primary", "secondary"], "consul": []}`)
+
+		case "/v1/health/service/web":
+			// Only the passing instance is returned when asked for
+			passing := `{
+				"Node": {"Node": "node-b", "Address": "10.0.0.2"},
+				"Service": {"ID": "web", "Service": "web", "Tags": ["primary"], "Port": 80, "Address": ""}
+			}`
+			failing := `{
+				"Node": {"Node": "node-a", "Address": "10.0.0.1"},
+				"Service": {"ID": "web", "Service": "web", "Tags": ["secondary"], "Port": 8080, "Address": "192.168.0.1"}
+			}`
+			if r.URL.Query().Get("passing") == "1" {
+				fmt.Fprintf(w, "[%s]", passing)
+			} else {
+				fmt.Fprintf(w, "[%s, %s]", passing, failing)
+			}
+			assert.Equal(t, "", r.URL.Query().Get("tag"))
+
+		case "/v1/health/service/db":
+			assert.Equal(t, "primary", r.URL.Query().Get("tag"))
+			fmt.Fprintln(w, `[]`)
+
+		default:
+			t.Error("Unexpected request:", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return url.Parse(server.URL)
+		},
+	}
+	httpClient := &http.Client{Transport: transport}
+	client := newTestClient(httpClient)
+
+	stubTemplate := "services.tmpl"
+	stubContent := `{{ range services }}{{ .Name }} {{ .Tags }}
+{{ end }}{{ range service "web" }}passing {{ .Node }} {{ .Address }}:{{ .Port }}
+{{ end }}{{ range service "web" "any" }}any {{ .Node }} {{ .Address }}:{{ .Port }} {{ .Tags }}
+{{ end }}{{ range service "primary.db" }}db {{ .Address }}
+{{ end }}`
+	err := ioutil.WriteFile(stubTemplate, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubTemplate)
+
+	renderer := NewTemplateRenderer(client)
+	rendered, err := renderer.Render(stubTemplate)
+	assert.Nil(t, err)
+	assert.Equal(t, `consul []
+web [primary secondary]
+passing node-b 10.0.0.2:80
+any node-a 192.168.0.1:8080 [secondary]
+any node-b 10.0.0.2:80 [primary]
+`, rendered)
+
+	// Every query is watched for membership changes
+	assert.Equal(t, 4, len(renderer.queries))
+}
+
+func TestRenderServiceUnknownFilter(t *testing.T) {
+
+	stubTemplate := "services.tmpl"
+	err := ioutil.WriteFile(stubTemplate, []byte(`{{ service "web" "healthy" }}`), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubTemplate)
+
+	_, err = RenderTemplate(stubTemplate, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Unknown health filter")
+}
@@ -0,0 +1,181 @@
+// toml.go
+package main
+
+import (
+	"encoding/json"
+	"github.com/BurntSushi/toml"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tomlReader maps the tables read from a TOML file onto nodes. The library
+// does not tell where a key was found, so the lines of keys are looked up in
+// the source, in the order the keys were read.
+type tomlReader struct {
+	fileName string
+	order    map[string]int
+	lines    map[string][]int
+}
+
+// keyLines finds the line of each key, in the order they were read. Keys of
+// array tables are read once for every table, and so have a line for each.
+func keyLines(src string, keys []toml.Key) map[string][]int {
+
+	lines := make(map[string][]int)
+	offset := 0
+	for _, key := range keys {
+		name := regexp.QuoteMeta(key[len(key)-1])
+		pattern := regexp.MustCompile(`(?m)(?:^|[\s\[{,.])(` + name + `|"` + name + `"|'` + name + `')\s*[=.\]]`)
+		line := 0
+		if match := pattern.FindStringSubmatchIndex(src[offset:]); match != nil {
+			line = strings.Count(src[:offset+match[2]], "\n") + 1
+			offset += match[3]
+		}
+		lines[key.String()] = append(lines[key.String()], line)
+	}
+	return lines
+}
+
+// line takes the next line a key was found on, or the line of its parent.
+func (reader *tomlReader) line(path toml.Key, parent int) int {
+
+	name := path.String()
+	if lines := reader.lines[name]; len(lines) > 0 {
+		reader.lines[name] = lines[1:]
+		if lines[0] > 0 {
+			return lines[0]
+		}
+	}
+	return parent
+}
+
+// peekLine finds the line a key is next found on, without taking it.
+func (reader *tomlReader) peekLine(path toml.Key, parent int) int {
+
+	if lines := reader.lines[path.String()]; len(lines) > 0 && lines[0] > 0 {
+		return lines[0]
+	}
+	return parent
+}
+
+func (reader *tomlReader) readTable(table map[string]interface{}, path toml.Key, line int) (*configNode, error) {
+
+	// Keys keep the order of the file, a table implied by a.b = 1 taking the
+	// place of its first key
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	position := func(key string) int {
+		if order, ok := reader.order[append(path[:len(path):len(path)], key).String()]; ok {
+			return order
+		}
+		return math.MaxInt32
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		if position(keys[i]) != position(keys[j]) {
+			return position(keys[i]) < position(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+
+	node := objectNode(line)
+	for _, key := range keys {
+		childPath := append(path[:len(path):len(path)], key)
+
+		// Each table of an array of tables has a header of its own
+		if tables, ok := table[key].([]map[string]interface{}); ok {
+			list := listNode(reader.peekLine(childPath, line))
+			for _, item := range tables {
+				child, err := reader.readTable(item, childPath, reader.line(childPath, line))
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, child)
+			}
+			node.set(key, list.line, list)
+			continue
+		}
+
+		childLine := reader.line(childPath, line)
+		child, err := reader.readValue(table[key], childPath, childLine)
+		if err != nil {
+			return nil, err
+		}
+		node.set(key, childLine, child)
+	}
+	return node, nil
+}
+
+func (reader *tomlReader) readValue(value interface{}, path toml.Key, line int) (*configNode, error) {
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return reader.readTable(value, path, line)
+	case []interface{}:
+		node := listNode(line)
+		for _, item := range value {
+			child, err := reader.readValue(item, path, line)
+			if err != nil {
+				return nil, err
+			}
+			node.items = append(node.items, child)
+		}
+		return node, nil
+	case int64:
+		return scalarNode(line, json.Number(strconv.FormatInt(value, 10))), nil
+	case float64:
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, syntaxError(reader.fileName, line, "%s cannot be used in a config", strconv.FormatFloat(value, 'g', -1, 64))
+		}
+		return scalarNode(line, json.Number(strconv.FormatFloat(value, 'g', -1, 64))), nil
+	case time.Time:
+		return scalarNode(line, tomlTime(value)), nil
+	case string, bool:
+		return scalarNode(line, value), nil
+	}
+	return nil, syntaxError(reader.fileName, line, "Unsupported value %v", value)
+}
+
+// tomlTime writes a date or time as it was written in the file.
+func tomlTime(value time.Time) string {
+
+	switch value.Location().String() {
+	case "date-local":
+		return value.Format("2006-01-02")
+	case "time-local":
+		return value.Format("15:04:05.999999999")
+	case "datetime-local":
+		return value.Format("2006-01-02T15:04:05.999999999")
+	}
+	return value.Format(time.RFC3339Nano)
+}
+
+// parseTOML reads a TOML file into nodes.
+func parseTOML(fileName string, src string) (*configNode, error) {
+
+	var table map[string]interface{}
+	metadata, err := toml.Decode(src, &table)
+	if err != nil {
+		if parseError, ok := err.(toml.ParseError); ok {
+			return nil, syntaxError(fileName, parseError.Position.Line, "%s", parseError.Message)
+		}
+		return nil, &ConfigError{File: fileName, Err: err}
+	}
+
+	reader := &tomlReader{fileName: fileName, order: make(map[string]int)}
+	keys := metadata.Keys()
+	for index, key := range keys {
+		for length := 1; length <= len(key); length++ {
+			if _, ok := reader.order[key[:length].String()]; !ok {
+				reader.order[key[:length].String()] = index
+			}
+		}
+	}
+	reader.lines = keyLines(src, keys)
+	return reader.readTable(table, toml.Key{}, 1)
+}
@@ -0,0 +1,111 @@
+// toml_test.go
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+
+	node, err := parseTOML("govern.toml", `
+# A comment
+name = "web" # and another
+port = 8_080
+mask = 0o644
+ratio = 5e-1
+released = 1979-05-27
+enabled = true
+literal = 'C:\path'
+"quoted key" = "tab\there \u00e9"
+server.host = "localhost"
+list = [
+  1,
+  2, # a comment
+]
+inline = { a = 1, b.c = "d" }
+script = """
+#!/bin/sh
+echo \
+  hi
+"""
+raw = '''
+a\nb'''
+
+[database]
+user = "governor"
+
+[database.replica]
+host = "replica"
+
+[[servers]]
+name = "a"
+
+[[servers]]
+name = "b"
+`)
+	assert.Nil(t, err)
+
+	expected := map[string]interface{}{
+		"name":       "web",
+		"port":       json.Number("8080"),
+		"mask":       json.Number("420"),
+		"ratio":      json.Number("0.5"),
+		"released":   "1979-05-27",
+		"enabled":    true,
+		"literal":    `C:\path`,
+		"quoted key": "tab\there é",
+		"server":     map[string]interface{}{"host": "localhost"},
+		"list":       []interface{}{json.Number("1"), json.Number("2")},
+		"inline": map[string]interface{}{
+			"a": json.Number("1"),
+			"b": map[string]interface{}{"c": "d"},
+		},
+		"script": "#!/bin/sh\necho hi\n",
+		"raw":    `a\nb`,
+		"database": map[string]interface{}{
+			"user":    "governor",
+			"replica": map[string]interface{}{"host": "replica"},
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"name": "a"},
+			map[string]interface{}{"name": "b"},
+		},
+	}
+	assert.Equal(t, expected, nodeJSON(t, node))
+}
+
+func TestParseTOMLLines(t *testing.T) {
+
+	node, err := parseTOML("govern.toml", "a = 1\n\n[b]\nc = \"a = 2\"\n\n[[d]]\ne = 3\n\n[[d]]\ne = 4\n")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, node.fields[0].line)
+	assert.Equal(t, 3, node.fields[1].line)
+	assert.Equal(t, 4, node.field("b").fields[0].line)
+	assert.Equal(t, 7, node.field("d").items[0].fields[0].line)
+	assert.Equal(t, 10, node.field("d").items[1].fields[0].line)
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+
+	cases := []struct {
+		contents string
+		message  string
+	}{
+		{"a = 1\na = 2\n", "govern.toml:2: Key 'a' has already been defined"},
+		{"[a]\n[a]\n", "govern.toml:2: Key 'a' has already been defined"},
+		{"a = [1]\n[[a]]\n", "govern.toml:2: Key 'a' was already created and cannot be used as an array"},
+		{"a = \"b\n", "govern.toml:1: strings cannot contain newlines"},
+		{"a = 1 b = 2\n", "govern.toml:1: expected a top-level item to end with a newline"},
+		{"a = nan\n", "govern.toml:1: NaN cannot be used in a config"},
+		{"a = yes\n", "govern.toml:1: expected value but found \"yes\""},
+	}
+
+	for _, c := range cases {
+		_, err := parseTOML("govern.toml", c.contents)
+		if assert.NotNil(t, err, c.contents) {
+			assert.Contains(t, err.Error(), c.message)
+		}
+	}
+}
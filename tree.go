@@ -0,0 +1,110 @@
+// tree.go
+package main
+
+import (
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func GetTree(prefix string, client ConsulClient) (map[string]string, error) {
+
+	log.Println("Attempting to retrieve prefix: ", prefix)
+	keyValues, _, err := client.List(prefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Error raised when attempting to list keys from consul: %s", err)
+	}
+
+	return treeFromPairs(prefix, keyValues), nil
+}
+
+// treeFromPairs maps each key under prefix, relative to it, to its value.
+// Folder keys carry no content and are left out.
+func treeFromPairs(prefix string, keyValues api.KVPairs) map[string]string {
+
+	tree := make(map[string]string)
+	folder := strings.TrimSuffix(prefix, "/") + "/"
+	for _, keyValue := range keyValues {
+
+		// Consul lists every key that starts with the prefix, such as
+		// application for app, and the prefix itself
+		if !strings.HasPrefix(keyValue.Key, folder) {
+			continue
+		}
+		name := strings.TrimPrefix(keyValue.Key, folder)
+		if name == "" || strings.HasSuffix(name, "/") {
+			continue
+		}
+		tree[name] = string(keyValue.Value)
+	}
+	return tree
+}
+
+// TreeFiles places every key of a tree as a file under directory. Keys that
+// would end up outside of the directory are skipped.
+func TreeFiles(directory string, tree map[string]string) map[string]string {
+
+	files := make(map[string]string)
+	for name, contents := range tree {
+		filePath := filepath.Join(directory, filepath.FromSlash(name))
+
+		relative, err := filepath.Rel(directory, filePath)
+		if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+			log.Println("Key would be written outside of its directory, skipping:", name)
+			continue
+		}
+		files[filePath] = contents
+	}
+	return files
+}
+
+// StaleFiles lists the files under directory that are not in keep.
+func StaleFiles(directory string, keep map[string]string) []string {
+
+	stale := []string{}
+	filepath.Walk(directory, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		if _, ok := keep[filePath]; !ok {
+			stale = append(stale, filePath)
+		}
+		return nil
+	})
+	return stale
+}
+
+// PruneTree removes the files under directory that are not in keep, along
+// with any folders that are left empty, and returns the removed files.
+func PruneTree(directory string, keep map[string]string) []string {
+
+	removed := []string{}
+	for _, filePath := range StaleFiles(directory, keep) {
+		log.Println("Key no longer exists, removing file:", filePath)
+		if err := os.Remove(filePath); err != nil {
+			log.Println("Could not remove file:", filePath, err)
+			continue
+		}
+		removed = append(removed, filePath)
+	}
+
+	folders := []string{}
+	filepath.Walk(directory, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() && filePath != directory {
+			folders = append(folders, filePath)
+		}
+		return nil
+	})
+
+	// Remove the deepest folders first, this fails for any that are not empty
+	sort.Sort(sort.Reverse(sort.StringSlice(folders)))
+	for _, folder := range folders {
+		os.Remove(folder)
+	}
+
+	return removed
+}
@@ -0,0 +1,133 @@
+// tree_test.go
+package main
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTreeFiles(t *testing.T) {
+
+	files := TreeFiles("mirror", map[string]string{
+		"a.conf":        "a",
+		"nested/b.conf": "b",
+		"../escape":     "escaped",
+	})
+
+	assert.Equal(t, map[string]string{
+		filepath.Join("mirror", "a.conf"):           "a",
+		filepath.Join("mirror", "nested", "b.conf"): "b",
+	}, files)
+}
+
+func TestTreeFromPairs(t *testing.T) {
+
+	tree := treeFromPairs("app", api.KVPairs{
+		{Key: "app", Value: []byte("self")},
+		{Key: "app/", Value: []byte("")},
+		{Key: "app/a.conf", Value: []byte("a")},
+		{Key: "application", Value: []byte("sibling")},
+		{Key: "application/b.conf", Value: []byte("b")},
+	})
+
+	assert.Equal(t, map[string]string{"a.conf": "a"}, tree)
+}
+
+func TestGovernTree(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"app/a.conf":        "a",
+		"app/nested/b.conf": "b",
+		"app/folder/":       "",
+		"application":       "not under the prefix",
+	})
+
+	// Leave files behind whose keys no longer exist
+	directory := "mirror"
+	defer os.RemoveAll(directory)
+	os.MkdirAll(filepath.Join(directory, "old"), 0755)
+	ioutil.WriteFile(filepath.Join(directory, "stale.conf"), []byte("stale"), 0644)
+	ioutil.WriteFile(filepath.Join(directory, "old", "c.conf"), []byte("c"), 0644)
+
+	stubConfig := "governor.conf"
+	stubContent := `{"app/": {"destination": "mirror", "tree": true, "prune": true}}`
+	err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	err = Govern([]string{stubConfig}, "", consul, FAIL_FAST)
+	assert.Nil(t, err)
+
+	// Every key is mirrored, creating folders for nested keys
+	contents, err := ioutil.ReadFile(filepath.Join(directory, "a.conf"))
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(contents))
+
+	contents, err = ioutil.ReadFile(filepath.Join(directory, "nested", "b.conf"))
+	assert.Nil(t, err)
+	assert.Equal(t, "b", string(contents))
+
+	// Files and folders of keys that disappeared are removed
+	_, err = os.Stat(filepath.Join(directory, "stale.conf"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(directory, "old"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Folder keys do not become files
+	_, err = os.Stat(filepath.Join(directory, "folder"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestGovernTreeEmptyPrefix(t *testing.T) {
+
+	consul := stubConsul(map[string]string{
+		"application": "not under the prefix",
+	})
+
+	directory := "mirror"
+	defer os.RemoveAll(directory)
+	os.MkdirAll(directory, 0755)
+	ioutil.WriteFile(filepath.Join(directory, "a.conf"), []byte("a"), 0644)
+
+	stubConfig := "governor.conf"
+	stubContent := `{"app/": {"destination": "mirror", "tree": true, "prune": true}}`
+	err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	err = Govern([]string{stubConfig}, "", consul, FAIL_FAST)
+	assert.Nil(t, err)
+
+	// A prefix without keys does not empty the directory
+	contents, err := ioutil.ReadFile(filepath.Join(directory, "a.conf"))
+	assert.Nil(t, err)
+	assert.Equal(t, "a", string(contents))
+}
+
+func TestEntryFilesChanged(t *testing.T) {
+
+	changed := map[string]bool{
+		"mirror/a.conf":        false,
+		"mirror/nested/b.conf": true,
+		"mirrored.conf":        true,
+	}
+
+	written, isChanged := ConfigEntry{Destination: "mirror", Tree: true}.filesChanged(changed)
+	assert.True(t, written)
+	assert.True(t, isChanged)
+
+	written, isChanged = ConfigEntry{Destination: "mirror/a.conf"}.filesChanged(changed)
+	assert.True(t, written)
+	assert.False(t, isChanged)
+
+	written, _ = ConfigEntry{Destination: "other", Tree: true}.filesChanged(changed)
+	assert.False(t, written)
+}
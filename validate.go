@@ -0,0 +1,189 @@
+// validate.go
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Validation is everything found wrong with a config file. Errors stop
+// governor from doing its job, while warnings may only not be what was meant.
+type Validation struct {
+	Entries  map[string]ConfigEntry
+	Errors   []error
+	Warnings []string
+}
+
+func (validation *Validation) Valid() bool {
+	return len(validation.Errors) == 0
+}
+
+func (validation *Validation) addError(name string, err error) {
+	validation.Errors = append(validation.Errors, &EntryError{Name: name, Err: err})
+}
+
+func (validation *Validation) addWarning(name string, format string, args ...interface{}) {
+	validation.Warnings = append(validation.Warnings, name+": "+fmt.Sprintf(format, args...))
+}
+
+// checkWritable makes sure governor can write filePath, or make it along with
+// any folders it needs.
+func checkWritable(filePath string, directory bool) error {
+
+	if info, err := os.Stat(filePath); err == nil {
+		switch {
+		case info.IsDir() && !directory:
+			return fmt.Errorf("%s is a directory", filePath)
+		case !info.IsDir() && directory:
+			return fmt.Errorf("%s is not a directory", filePath)
+		case directory:
+			if !writable(filePath) {
+				return fmt.Errorf("%s is not writable", filePath)
+			}
+			return nil
+		}
+	}
+
+	// Files are replaced within their folder, and missing folders are made
+	// in the closest one that exists
+	folder := filepath.Dir(filePath)
+	for {
+		info, err := os.Stat(folder)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", folder)
+			}
+			if !writable(folder) {
+				return fmt.Errorf("%s is not writable", folder)
+			}
+			return nil
+		}
+		parent := filepath.Dir(folder)
+		if parent == folder {
+			return nil
+		}
+		folder = parent
+	}
+}
+
+// checkKey looks up the key of an entry the way a run would, and returns a
+// warning when it is missing but the entry copes with that.
+func checkKey(entry ConfigEntry, client ConsulClient) (string, error) {
+
+	switch {
+	case entry.Template != "":
+		return "", nil
+	case entry.Tree:
+		tree, err := GetTree(entry.Key, client)
+		if err != nil {
+			return "", err
+		}
+		if len(tree) == 0 {
+			return fmt.Sprintf("there are no keys under %q", entry.Key), nil
+		}
+		return "", nil
+	}
+
+	_, err := GetAttribute(entry.Key, client)
+	if _, missing := err.(*KeyNotFoundError); missing {
+		switch {
+		case entry.Optional != "":
+			return fmt.Sprintf("key %q does not exist, and is optional", entry.Key), nil
+		case entry.Default != nil || entry.DefaultFile != "":
+			return fmt.Sprintf("key %q does not exist, so its default is used", entry.Key), nil
+		}
+		return "", fmt.Errorf("Key %q does not exist", entry.Key)
+	}
+	return "", err
+}
+
+// ValidateConfig checks everything governor reads from the config file, along
+// with the Consul settings and templates, without writing any file. Keys are
+// only looked up when a client is given.
+func ValidateConfig(configFiles []string, format string, consulFlags ConsulConfig, client ConsulClient) *Validation {
+
+	validation := &Validation{}
+
+	// Nothing else can be checked without the entries
+	if err := checkFileExists(configFiles); err != nil {
+		validation.Errors = append(validation.Errors, err)
+		return validation
+	}
+	configMap, err := GetConfigFromFiles(configFiles, format)
+	if err != nil {
+		validation.Errors = append(validation.Errors, err)
+		return validation
+	}
+	validation.Entries = configMap
+
+	envConfig, err := GetEnvConfigFromFiles(configFiles, format)
+	if err == nil {
+		err = envConfig.Validate()
+	}
+	if err != nil {
+		validation.Errors = append(validation.Errors, err)
+	}
+
+	consulConfig, err := LoadConsulConfig(configFiles, format, consulFlags)
+	if err == nil {
+		_, err = consulConfig.RetryPolicy()
+	}
+	if err == nil {
+		_, err = consulConfig.APIConfig()
+	}
+	if err != nil {
+		validation.Errors = append(validation.Errors, fmt.Errorf("Invalid Consul settings: %s", err))
+	}
+
+	names := []string{}
+	for name := range configMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		entry := configMap[name]
+
+		// Owners and groups have to exist on this host
+		if _, err := entry.ResolvePermissions(); err != nil {
+			validation.addError(name, err)
+		}
+
+		for _, target := range entry.Targets() {
+			if !filepath.IsAbs(target.Destination) {
+				validation.addWarning(name, "destination %q is relative to where governor runs", target.Destination)
+			}
+			if err := checkWritable(target.Destination, target.Tree); err != nil {
+				validation.addError(name, err)
+			}
+		}
+
+		if entry.DefaultFile != "" {
+			if _, err := entry.DefaultContent(); err != nil {
+				validation.addError(name, err)
+			}
+		}
+
+		// Templates are parsed, but only rendered by a run
+		if entry.Template != "" {
+			if _, err := NewTemplateRenderer(client).parse(entry.Template); err != nil {
+				validation.addError(name, err)
+			}
+		}
+
+		if client == nil {
+			continue
+		}
+		warning, err := checkKey(entry, client)
+		if err != nil {
+			validation.addError(name, err)
+		}
+		if warning != "" {
+			validation.addWarning(name, "%s", warning)
+		}
+	}
+
+	return validation
+}
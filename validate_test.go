@@ -0,0 +1,134 @@
+// validate_test.go
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func validateContents(t *testing.T, contents string, client ConsulClient) *Validation {
+
+	stubConfig := "validate.conf"
+	err := ioutil.WriteFile(stubConfig, []byte(contents), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+	return ValidateConfig([]string{stubConfig}, "", ConsulConfig{}, client)
+}
+
+func TestValidateConfig(t *testing.T) {
+
+	directory, _ := ioutil.TempDir("", "validate")
+	defer os.RemoveAll(directory)
+
+	cases := []struct {
+		contents string
+		valid    bool
+	}{
+		{`{"version": 2, "entries": [{"key": "a", "destination": "%s/a.conf"}]}`, true},
+		{`{"version": 2, "entries": [{"key": "a", "destination": "%s/a.conf",}]}`, false},
+		{`{"version": 2, "entries": [{"key": "a", "destination": "%s/a.conf", "mode": "0999"}]}`, false},
+		{`{"version": 2, "entries": [{"key": "a", "destination": "%s/a.conf", "owner": "no-such-user-governor"}]}`, false},
+		{`{"version": 2, "entries": [{"key": "a", "destination": "%s/a.conf", "default_file": "/no/such/default"}]}`, false},
+		{`{"version": 2, "env": {"keys": ["=a"]}, "entries": []}`, false},
+		{`{"version": 2, "consul": {"retry_backoff": "soon"}, "entries": []}`, false},
+		{`{"version": 2, "consul": {"ca_file": "/no/such/ca.pem"}, "entries": []}`, false},
+	}
+
+	for _, c := range cases {
+		contents := c.contents
+		if strings.Contains(contents, "%s") {
+			contents = fmt.Sprintf(contents, directory)
+		}
+		validation := validateContents(t, contents, nil)
+		assert.Equal(t, c.valid, validation.Valid(), contents)
+	}
+
+	validation := ValidateConfig([]string{"missing.conf"}, "", ConsulConfig{}, nil)
+	assert.False(t, validation.Valid())
+}
+
+func TestValidateTemplates(t *testing.T) {
+
+	directory, _ := ioutil.TempDir("", "validate")
+	defer os.RemoveAll(directory)
+
+	valid := filepath.Join(directory, "valid.tmpl")
+	invalid := filepath.Join(directory, "invalid.tmpl")
+	ioutil.WriteFile(valid, []byte(`{{ key "a" }}`), 0644)
+	ioutil.WriteFile(invalid, []byte(`{{ key "a" `), 0644)
+
+	cases := []struct {
+		template string
+		valid    bool
+	}{
+		{valid, true},
+		{invalid, false},
+		{filepath.Join(directory, "missing.tmpl"), false},
+	}
+
+	for _, c := range cases {
+		contents := fmt.Sprintf(`{"version": 2, "entries": [{"name": "a", "template": "%s", "destination": "%s/a.conf"}]}`, c.template, directory)
+		validation := validateContents(t, contents, nil)
+		assert.Equal(t, c.valid, validation.Valid(), c.template)
+	}
+}
+
+func TestValidateDestinationPaths(t *testing.T) {
+
+	directory, _ := ioutil.TempDir("", "validate")
+	defer os.RemoveAll(directory)
+	blocker := filepath.Join(directory, "blocker")
+	assert.Nil(t, ioutil.WriteFile(blocker, []byte{}, 0644))
+
+	// Relative paths are allowed, but may not be what was meant
+	validation := validateContents(t, `{"a": "a.conf"}`, nil)
+	assert.True(t, validation.Valid())
+	assert.Equal(t, []string{`a: destination "a.conf" is relative to where governor runs`}, validation.Warnings)
+
+	// Missing folders are made, but not under a file
+	config := fmt.Sprintf(`{"a": "%[1]s/new/folder/a.conf", "b": "%[1]s/blocker/b.conf", "c": "%[1]s", "d": {"destination": "%[1]s/blocker", "tree": true}}`, directory)
+	validation = validateContents(t, config, nil)
+	assert.Empty(t, validation.Warnings)
+	if assert.Equal(t, 3, len(validation.Errors)) {
+		assert.Equal(t, "b: "+blocker+" is not a directory", validation.Errors[0].Error())
+		assert.Equal(t, "c: "+directory+" is a directory", validation.Errors[1].Error())
+		assert.Equal(t, "d: "+blocker+" is not a directory", validation.Errors[2].Error())
+	}
+}
+
+func TestValidateKeys(t *testing.T) {
+
+	directory, _ := ioutil.TempDir("", "validate")
+	defer os.RemoveAll(directory)
+
+	consul := stubConsul(map[string]string{
+		"present":    "value",
+		"tree/a.txt": "a",
+	})
+	config := fmt.Sprintf(`{"version": 2, "entries": [
+  {"key": "present", "destination": "%[1]s/present.conf"},
+  {"key": "missing", "destination": "%[1]s/missing.conf"},
+  {"key": "optional", "destination": "%[1]s/optional.conf", "optional": "skip"},
+  {"key": "defaulted", "destination": "%[1]s/defaulted.conf", "default": ""},
+  {"key": "tree", "destination": "%[1]s/tree", "tree": true},
+  {"key": "empty", "destination": "%[1]s/empty", "tree": true}
+]}`, directory)
+
+	// Keys are only looked up when asked to
+	assert.True(t, validateContents(t, config, nil).Valid())
+
+	validation := validateContents(t, config, consul)
+	assert.Equal(t, []error{&EntryError{Name: "missing", Err: fmt.Errorf(`Key "missing" does not exist`)}}, validation.Errors)
+	assert.Equal(t, []string{
+		`defaulted: key "defaulted" does not exist, so its default is used`,
+		`empty: there are no keys under "empty"`,
+		`optional: key "optional" does not exist, and is optional`,
+	}, validation.Warnings)
+}
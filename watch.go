@@ -0,0 +1,355 @@
+// watch.go
+package main
+
+import (
+	"github.com/hashicorp/consul/api"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WATCH_WAIT_TIME  time.Duration = 5 * time.Minute
+	WATCH_RETRY_WAIT time.Duration = 5 * time.Second
+)
+
+type watchResult struct {
+	keyValue  *api.KVPair
+	keyValues api.KVPairs
+	lastIndex uint64
+	err       error
+}
+
+// WatchAttribute performs a blocking query on key, returning once its index
+// moves past waitIndex or the wait time expires.
+func WatchAttribute(key string, waitIndex uint64, client ConsulClient) (*api.KVPair, uint64, error) {
+
+	// Block until the key changes, or the wait time runs out
+	options := &api.QueryOptions{
+		WaitIndex: waitIndex,
+		WaitTime:  WATCH_WAIT_TIME,
+	}
+	keyValue, meta, err := client.Get(key, options)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return keyValue, meta.LastIndex, nil
+}
+
+// WatchTree performs a blocking query on every key under prefix, returning
+// once their index moves past waitIndex or the wait time expires.
+func WatchTree(prefix string, waitIndex uint64, client ConsulClient) (api.KVPairs, uint64, error) {
+
+	// Block until any key changes, or the wait time runs out
+	options := &api.QueryOptions{
+		WaitIndex: waitIndex,
+		WaitTime:  WATCH_WAIT_TIME,
+	}
+	keyValues, meta, err := client.List(prefix, options)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return keyValues, meta.LastIndex, nil
+}
+
+// runQuery runs the blocking query in the background so that we can still
+// stop. It returns false if it was stopped first.
+func runQuery(query func() watchResult, stopCh <-chan struct{}) (watchResult, bool) {
+
+	resultCh := make(chan watchResult, 1)
+	go func() {
+		resultCh <- query()
+	}()
+
+	select {
+	case <-stopCh:
+		return watchResult{}, false
+	case result := <-resultCh:
+		return result, true
+	}
+}
+
+// waitToRetry returns false if it was stopped before it was time to retry.
+func waitToRetry(stopCh <-chan struct{}) bool {
+
+	select {
+	case <-stopCh:
+		return false
+	case <-time.After(WATCH_RETRY_WAIT):
+		return true
+	}
+}
+
+func watchKey(entry ConfigEntry, permissions []FilePermissions, client ConsulClient, stopCh <-chan struct{}, onChange func()) {
+
+	key := entry.Key
+	var waitIndex, modifyIndex uint64
+	handled := false
+	for {
+
+		result, ok := runQuery(func() watchResult {
+			keyValue, lastIndex, err := WatchAttribute(key, waitIndex, client)
+			return watchResult{keyValue: keyValue, lastIndex: lastIndex, err: err}
+		}, stopCh)
+		if !ok {
+			return
+		}
+
+		if result.err != nil {
+			log.Println("Error raised when watching key", key, "retrying:", result.err)
+			if !waitToRetry(stopCh) {
+				return
+			}
+			continue
+		}
+
+		// Consul may reset its index, in which case we start over
+		if result.lastIndex < waitIndex {
+			waitIndex = 0
+		} else {
+			waitIndex = result.lastIndex
+		}
+
+		// A missing key falls back to its default, which has no index
+		initial := !handled
+		var content string
+		if result.keyValue == nil {
+			if handled && modifyIndex == 0 {
+				continue
+			}
+
+			defaultContent, err := entry.DefaultContent()
+			if err != nil {
+				log.Println("Could not use the default of key", key, err)
+				continue
+			}
+
+			// Optional keys are only handled once for as long as they are missing
+			if defaultContent == nil {
+				remove, err := entry.missingKey(&KeyNotFoundError{Key: key})
+				if err != nil {
+					log.Println(err)
+					continue
+				}
+				handled = true
+				modifyIndex = 0
+				if remove {
+					removed, err := entry.RemoveFiles()
+					if err != nil {
+						log.Println(err)
+					}
+					changed := make(map[string]bool)
+					for _, filePath := range removed {
+						changed[filePath] = true
+					}
+					handleChanges(entry, changed, onChange, initial)
+				}
+				continue
+			}
+
+			modifyIndex = 0
+			content = *defaultContent
+			log.Println("Key does not exist, using its default:", key)
+		} else {
+
+			// Only rewrite the file if the key itself was modified
+			if handled && result.keyValue.ModifyIndex == modifyIndex {
+				continue
+			}
+			modifyIndex = result.keyValue.ModifyIndex
+			log.Println("Key", key, "changed at index", modifyIndex)
+
+			var err error
+			content, err = entry.DecodeValue(string(result.keyValue.Value))
+			if err != nil {
+				log.Println("Could not decode key", key, err)
+				continue
+			}
+		}
+		handled = true
+
+		files := entry.Files(content)
+		changed, err := MakeConfigFiles(files, entry.FilePermissions(files, permissions))
+		if err != nil {
+			log.Println(err)
+		}
+		handleChanges(entry, changed, onChange, initial)
+	}
+}
+
+func watchTemplate(entry ConfigEntry, permissions []FilePermissions, client ConsulClient, stopCh <-chan struct{}, onChange func()) {
+
+	renderer := NewTemplateRenderer(client)
+	var lastContent *string
+	for {
+
+		content, err := renderer.Render(entry.Template)
+		if err != nil {
+			log.Println("Error raised when rendering template", entry.Template, "retrying:", err)
+			if !waitToRetry(stopCh) {
+				return
+			}
+			continue
+		}
+
+		// Only rewrite the file if the rendered output changed
+		if lastContent == nil || *lastContent != content {
+			initial := lastContent == nil
+			lastContent = &content
+
+			log.Println("Template", entry.Template, "rendered new content")
+			files := entry.Files(content)
+			changed, err := MakeConfigFiles(files, entry.FilePermissions(files, permissions))
+			if err != nil {
+				log.Println(err)
+			}
+			handleChanges(entry, changed, onChange, initial)
+		}
+
+		// Block until anything the template used changes
+		if !renderer.WaitForChange(stopCh) {
+			return
+		}
+	}
+}
+
+func watchTree(entry ConfigEntry, permissions []FilePermissions, client ConsulClient, stopCh <-chan struct{}, onChange func()) {
+
+	prefix := entry.Key
+	var waitIndex uint64
+	handled := false
+	for {
+
+		result, ok := runQuery(func() watchResult {
+			keyValues, lastIndex, err := WatchTree(prefix, waitIndex, client)
+			return watchResult{keyValues: keyValues, lastIndex: lastIndex, err: err}
+		}, stopCh)
+		if !ok {
+			return
+		}
+
+		if result.err != nil {
+			log.Println("Error raised when watching prefix", prefix, "retrying:", result.err)
+			if !waitToRetry(stopCh) {
+				return
+			}
+			continue
+		}
+
+		// Only rewrite the files if something under the prefix changed
+		if result.lastIndex == waitIndex {
+			continue
+		}
+		waitIndex = result.lastIndex
+
+		log.Println("Prefix", prefix, "changed at index", waitIndex)
+		tree := treeFromPairs(prefix, result.keyValues)
+
+		// A key that cannot be decoded leaves the files as they are, as a
+		// partial tree would prune its file, until the prefix changes again
+		decoded := true
+		for name, value := range tree {
+			content, err := entry.DecodeValue(value)
+			if err != nil {
+				log.Println("Could not decode key", name, err)
+				decoded = false
+				break
+			}
+			tree[name] = content
+		}
+		if !decoded {
+			continue
+		}
+		files := entry.TreeFiles(tree)
+		changed, err := MakeConfigFiles(files, entry.FilePermissions(files, permissions))
+		if err != nil {
+			log.Println(err)
+		}
+		if entry.Prune {
+			for _, filePath := range entry.PruneFiles(files) {
+				changed[filePath] = true
+			}
+		}
+		handleChanges(entry, changed, onChange, !handled)
+		handled = true
+	}
+}
+
+// handleChanges runs the commands of an entry whose files were written, and
+// calls onChange if any of them changed. The files are written once before
+// watching, which runs their commands, so the initial pass only runs them for
+// files that changed since.
+func handleChanges(entry ConfigEntry, changed map[string]bool, onChange func(), initial bool) {
+
+	if initial {
+		changedOnly := make(map[string]bool)
+		for filePath, isChanged := range changed {
+			if isChanged {
+				changedOnly[filePath] = true
+			}
+		}
+		changed = changedOnly
+	}
+	RunEntryCommands(map[string]ConfigEntry{entry.Name: entry}, changed)
+	if onChange == nil {
+		return
+	}
+	for _, isChanged := range changed {
+		if isChanged {
+			onChange()
+			return
+		}
+	}
+}
+
+func Watch(configFiles []string, format string, client ConsulClient, stopCh <-chan struct{}) error {
+	return WatchWithNotify(configFiles, format, client, stopCh, nil)
+}
+
+// WatchWithNotify watches like Watch, and also calls onChange whenever a file
+// changed on disk.
+func WatchWithNotify(configFiles []string, format string, client ConsulClient, stopCh <-chan struct{}, onChange func()) error {
+
+	// Parse the config file
+	configMap, err := GetConfigFromFiles(configFiles, format)
+	if err != nil {
+		return err
+	}
+
+	// Resolve the permissions up front, as they cannot change while watching
+	permissions := make(map[string][]FilePermissions)
+	for name, entry := range configMap {
+		resolved, err := entry.ResolvePermissions()
+		if err != nil {
+			return &ConfigError{File: strings.Join(configFiles, ", "), Err: &EntryError{Name: name, Err: err}}
+		}
+		permissions[name] = resolved
+	}
+
+	// Watch every key until we are told to stop
+	var wg sync.WaitGroup
+	for name, entry := range configMap {
+		for _, target := range entry.Targets() {
+			log.Println("Watching", name, "for", target.Destination)
+		}
+
+		wg.Add(1)
+		go func(entry ConfigEntry, filePermissions []FilePermissions) {
+			defer wg.Done()
+			switch {
+			case entry.Tree:
+				watchTree(entry, filePermissions, client, stopCh, onChange)
+			case entry.Template != "":
+				watchTemplate(entry, filePermissions, client, stopCh, onChange)
+			default:
+				watchKey(entry, filePermissions, client, stopCh, onChange)
+			}
+		}(entry, permissions[name])
+	}
+
+	wg.Wait()
+	return nil
+}
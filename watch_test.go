@@ -0,0 +1,205 @@
+// watch_test.go
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitForContents(fileName string, expected string) string {
+
+	var contents []byte
+	for i := 0; i < 100; i++ {
+		contents, _ = ioutil.ReadFile(fileName)
+		if string(contents) == expected {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return string(contents)
+}
+
+func TestWatchRewritesChangedKey(t *testing.T) {
+
+	// Each index the stub server knows about, and the value at that index
+	values := map[string]StubConfig{
+		"":   {key: "10", value: "first"},
+		"10": {key: "11", value: "second"},
+		"11": {key: "11", value: "second"},
+	}
+	requested := make(chan string, 100)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index := r.URL.Query().Get("index")
+		select {
+		case requested <- index:
+		default:
+		}
+
+		// Simulate a blocking query that times out without a change
+		if index == "11" {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		stub := values[index]
+		valueBase64 := base64.StdEncoding.EncodeToString([]byte(stub.value))
+
+		w.Header().Set("X-Consul-Index", stub.key)
+		w.WriteHeader(200)
+		fmt.Fprintf(w, `[{
+			"CreateIndex": 10,
+			"ModifyIndex": %s,
+			"LockIndex": 0,
+			"Key": "ssl_key",
+			"Flags": 0,
+			"Value": "%s"
+		}]`, stub.key, valueBase64)
+	}))
+	defer server.Close()
+
+	// Make a transport that reroutes all traffic to the example server
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return url.Parse(server.URL)
+		},
+	}
+	httpClient := &http.Client{Transport: transport}
+	client := newTestClient(httpClient)
+
+	// Make a stub config file
+	stubConfig := "watch.conf"
+	stubFile := "watched.conf"
+	err := ioutil.WriteFile(stubConfig, []byte(fmt.Sprintf(`{"ssl_key": "%s"}`, stubFile)), 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+	defer os.Remove(stubFile)
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		assert.Nil(t, Watch([]string{stubConfig}, "", client, stopCh))
+		close(doneCh)
+	}()
+
+	// The file should follow the key to its latest value
+	assert.Equal(t, "second", waitForContents(stubFile, "second"))
+	assert.Equal(t, "", <-requested)
+	assert.Equal(t, "10", <-requested)
+	assert.Equal(t, "11", <-requested)
+
+	// An unchanged modify index should not rewrite the file
+	os.Remove(stubFile)
+	<-requested
+	<-requested
+	_, err = os.Stat(stubFile)
+	assert.True(t, os.IsNotExist(err))
+
+	close(stopCh)
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not stop after being signalled")
+	}
+}
+
+func TestWatchTreeKeepsFilesOnDecodeError(t *testing.T) {
+
+	encode := func(value string) string {
+		return base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	// The first tree has a value that is not base64, the second fixes it
+	trees := map[string]struct {
+		index string
+		bad   string
+	}{
+		"":   {index: "10", bad: "not base64!"},
+		"10": {index: "11", bad: encode("fixed")},
+		"11": {index: "11", bad: encode("fixed")},
+	}
+	requested := make(chan string, 100)
+	release := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index := r.URL.Query().Get("index")
+		select {
+		case requested <- index:
+		default:
+		}
+
+		// Hold the change back until the first tree has been checked
+		if index == "10" {
+			<-release
+		}
+		if index == "11" {
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		tree := trees[index]
+		w.Header().Set("X-Consul-Index", tree.index)
+		w.WriteHeader(200)
+		fmt.Fprintf(w, `[
+			{"CreateIndex": 10, "ModifyIndex": 10, "LockIndex": 0, "Key": "app/a.conf", "Flags": 0, "Value": "%s"},
+			{"CreateIndex": 10, "ModifyIndex": %s, "LockIndex": 0, "Key": "app/bad", "Flags": 0, "Value": "%s"}
+		]`, encode(encode("a")), tree.index, encode(tree.bad))
+	}))
+	defer server.Close()
+
+	transport := &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			return url.Parse(server.URL)
+		},
+	}
+	client := newTestClient(&http.Client{Transport: transport})
+
+	directory := "watched"
+	defer os.RemoveAll(directory)
+	os.MkdirAll(directory, 0755)
+	ioutil.WriteFile(filepath.Join(directory, "bad"), []byte("old"), 0644)
+
+	stubConfig := "watch.conf"
+	stubContent := `{"app/": {"destination": "watched", "tree": true, "prune": true, "decode": "base64"}}`
+	if err := ioutil.WriteFile(stubConfig, []byte(stubContent), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove(stubConfig)
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		assert.Nil(t, Watch([]string{stubConfig}, "", client, stopCh))
+		close(doneCh)
+	}()
+
+	// A key that cannot be decoded is neither written nor pruned
+	assert.Equal(t, "", <-requested)
+	assert.Equal(t, "10", <-requested)
+	contents, err := ioutil.ReadFile(filepath.Join(directory, "bad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(contents))
+	_, err = os.Stat(filepath.Join(directory, "a.conf"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Once the prefix changes, the whole tree is written
+	close(release)
+	assert.Equal(t, "fixed", waitForContents(filepath.Join(directory, "bad"), "fixed"))
+	assert.Equal(t, "a", waitForContents(filepath.Join(directory, "a.conf"), "a"))
+
+	close(stopCh)
+	select {
+	case <-doneCh:
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not stop after being signalled")
+	}
+}
@@ -0,0 +1,286 @@
+// write.go
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	FILE_MODE   os.FileMode = 0640
+	FOLDER_MODE os.FileMode = 0750
+)
+
+var tempCounter uint64
+
+// makeFolder creates dirPath and any missing parents, returning the folders
+// it created from the outermost inwards.
+func makeFolder(dirPath string, permissions FilePermissions) ([]string, error) {
+
+	// Find every missing folder on the way up
+	missing := []string{}
+	for folder := dirPath; ; folder = filepath.Dir(folder) {
+		if _, err := os.Stat(folder); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		missing = append([]string{folder}, missing...)
+		if filepath.Dir(folder) == folder {
+			break
+		}
+	}
+	if len(missing) == 0 {
+		return missing, nil
+	}
+
+	log.Println("Folder does not exist, making:", dirPath)
+	if err := os.MkdirAll(dirPath, permissions.DirMode); err != nil {
+		return nil, err
+	}
+
+	// Only the folders we made are given our permissions
+	for _, folder := range missing {
+		if err := applyPermissions(folder, permissions.DirMode, permissions); err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// writeTempFile writes contents to a new hidden file next to filePath, and
+// makes sure it reached the disk.
+func writeTempFile(filePath string, contents []byte, permissions FilePermissions) (string, error) {
+
+	dirPath, baseName := filepath.Split(filePath)
+
+	// Pick a name that nobody else is using, that only we can read for now
+	var tempFile *os.File
+	var tempPath string
+	for {
+		suffix := fmt.Sprintf("%d.%d", time.Now().UnixNano(), atomic.AddUint64(&tempCounter, 1))
+		tempPath = filepath.Join(dirPath, "."+baseName+".governor-"+suffix)
+
+		var err error
+		tempFile, err = os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+
+	_, err := tempFile.Write(contents)
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = applyPermissions(tempPath, permissions.Mode, permissions)
+	}
+
+	if err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
+	return tempPath, nil
+}
+
+// syncFolder makes sure a rename within dirPath reached the disk.
+func syncFolder(dirPath string) {
+
+	folder, err := os.Open(dirPath)
+	if err != nil {
+		return
+	}
+	folder.Sync()
+	folder.Close()
+}
+
+// resolveLink follows filePath to the file it links to, so that the link is
+// kept and the file behind it is replaced. A path that does not exist yet is
+// written as it is.
+func resolveLink(filePath string) string {
+
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return filePath
+	}
+	return resolved
+}
+
+// WriteFileAtomic replaces filePath with contents, so that readers only ever
+// see the old or the new content, never a partially written file.
+func WriteFileAtomic(filePath string, contents []byte, permissions FilePermissions) error {
+
+	filePath = resolveLink(filePath)
+	tempPath, err := writeTempFile(filePath, contents, permissions)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempPath, filePath); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	syncFolder(filepath.Dir(filePath))
+	return nil
+}
+
+type stagedFile struct {
+	filePath   string
+	tempPath   string
+	backupPath string
+	committed  bool
+}
+
+// Transaction stages a set of files and replaces them all together. If any
+// of them cannot be replaced, every file is put back as it was.
+type Transaction struct {
+	staged  []*stagedFile
+	folders []string
+}
+
+func (tx *Transaction) Stage(filePath string, contents []byte, permissions FilePermissions) error {
+
+	folders, err := makeFolder(filepath.Dir(filePath), permissions)
+	if err != nil {
+		return err
+	}
+	tx.folders = append(tx.folders, folders...)
+
+	filePath = resolveLink(filePath)
+	tempPath, err := writeTempFile(filePath, contents, permissions)
+	if err != nil {
+		return err
+	}
+
+	tx.staged = append(tx.staged, &stagedFile{filePath: filePath, tempPath: tempPath})
+	return nil
+}
+
+func (tx *Transaction) Commit() error {
+
+	for _, staged := range tx.staged {
+
+		// Keep the original around until every file is in place
+		if _, err := os.Stat(staged.filePath); err == nil {
+			staged.backupPath = staged.tempPath + ".backup"
+			if err := os.Link(staged.filePath, staged.backupPath); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		if err := os.Rename(staged.tempPath, staged.filePath); err != nil {
+			tx.Rollback()
+			return err
+		}
+		staged.committed = true
+	}
+
+	// Everything is in place, so the originals can go
+	for _, staged := range tx.staged {
+		if staged.backupPath != "" {
+			os.Remove(staged.backupPath)
+		}
+		syncFolder(filepath.Dir(staged.filePath))
+	}
+	return nil
+}
+
+func (tx *Transaction) Rollback() {
+
+	for i := len(tx.staged) - 1; i >= 0; i-- {
+		staged := tx.staged[i]
+
+		if !staged.committed {
+			os.Remove(staged.tempPath)
+			if staged.backupPath != "" {
+				os.Remove(staged.backupPath)
+			}
+			continue
+		}
+
+		// Put back the original, or remove a file that did not exist before
+		log.Println("Rolling back config file:", staged.filePath)
+		if staged.backupPath != "" {
+			os.Rename(staged.backupPath, staged.filePath)
+		} else {
+			os.Remove(staged.filePath)
+		}
+	}
+
+	// Remove the folders we created, innermost first
+	for i := len(tx.folders) - 1; i >= 0; i-- {
+		os.Remove(tx.folders[i])
+	}
+}
+
+// fileStatus compares a file on disk with the content and permissions it
+// should have.
+func fileStatus(filePath string, contents string, permissions FilePermissions) string {
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return STATUS_CREATE
+	}
+
+	existing, err := ioutil.ReadFile(filePath)
+	if err != nil || string(existing) != contents {
+		return STATUS_UPDATE
+	}
+
+	if info.Mode().Perm() != permissions.Mode {
+		return STATUS_UPDATE
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		if permissions.Uid != -1 && uid != permissions.Uid {
+			return STATUS_UPDATE
+		}
+		if permissions.Gid != -1 && gid != permissions.Gid {
+			return STATUS_UPDATE
+		}
+	}
+	return STATUS_UNCHANGED
+}
+
+// CommitConfigFiles writes every file, or none at all if any of them fails.
+func CommitConfigFiles(configMap map[string]string, permissions map[string]FilePermissions) (map[string]bool, error) {
+
+	tx := &Transaction{}
+	changed := make(map[string]bool)
+	for filePath, fileContents := range configMap {
+		filePermissions := permissionsFor(permissions, filePath)
+
+		// Leave the file alone if it is already as it should be
+		if fileStatus(filePath, fileContents, filePermissions) == STATUS_UNCHANGED {
+			log.Printf("Config file %s is unchanged, not staging\n", filePath)
+			changed[filePath] = false
+			continue
+		}
+		changed[filePath] = true
+
+		log.Printf("Staging config file %s\n", filePath)
+		if err := tx.Stage(filePath, []byte(fileContents), filePermissions); err != nil {
+			tx.Rollback()
+			return map[string]bool{}, &WriteError{Errors: []error{&EntryError{Name: filePath, Err: err}}}
+		}
+	}
+
+	log.Println("Committing", len(tx.staged), "config file(s)")
+	if err := tx.Commit(); err != nil {
+		return map[string]bool{}, &WriteError{Errors: []error{err}}
+	}
+	return changed, nil
+}
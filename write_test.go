@@ -0,0 +1,154 @@
+// write_test.go
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+
+	stubFile := "atomic.conf"
+	defer os.Remove(stubFile)
+
+	assert.Nil(t, WriteFileAtomic(stubFile, []byte("first"), DefaultPermissions))
+
+	info, err := os.Stat(stubFile)
+	assert.Nil(t, err)
+	assert.Equal(t, FILE_MODE, info.Mode().Perm())
+
+	// The configured mode replaces whatever the file had before
+	os.Chmod(stubFile, 0666)
+	permissions := DefaultPermissions
+	permissions.Mode = 0600
+	assert.Nil(t, WriteFileAtomic(stubFile, []byte("second"), permissions))
+
+	contents, err := ioutil.ReadFile(stubFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(contents))
+
+	info, err = os.Stat(stubFile)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// No temporary files are left behind
+	leftovers, _ := filepath.Glob(".atomic.conf.governor-*")
+	assert.Equal(t, 0, len(leftovers))
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+
+	directory, _ := ioutil.TempDir("", "symlink")
+	defer os.RemoveAll(directory)
+
+	target := filepath.Join(directory, "target.conf")
+	link := filepath.Join(directory, "link.conf")
+	ioutil.WriteFile(target, []byte("first"), 0644)
+	os.Symlink(target, link)
+
+	assert.Nil(t, WriteFileAtomic(link, []byte("second"), DefaultPermissions))
+
+	// The link is kept, and the file it points to is replaced
+	info, err := os.Lstat(link)
+	assert.Nil(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+
+	contents, err := ioutil.ReadFile(target)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", string(contents))
+}
+
+func TestTransactionRollback(t *testing.T) {
+
+	directory := "transaction"
+	defer os.RemoveAll(directory)
+	os.MkdirAll(directory, 0755)
+
+	existing := filepath.Join(directory, "existing.conf")
+	created := filepath.Join(directory, "nested", "created.conf")
+	ioutil.WriteFile(existing, []byte("original"), 0644)
+
+	tx := &Transaction{}
+	assert.Nil(t, tx.Stage(existing, []byte("replaced"), DefaultPermissions))
+	assert.Nil(t, tx.Stage(created, []byte("created"), DefaultPermissions))
+
+	// Nothing changes until the transaction is committed
+	contents, _ := ioutil.ReadFile(existing)
+	assert.Equal(t, "original", string(contents))
+	_, err := os.Stat(created)
+	assert.True(t, os.IsNotExist(err))
+
+	// A file that cannot be replaced undoes the whole transaction
+	blocked := filepath.Join(directory, "blocked")
+	os.MkdirAll(filepath.Join(blocked, "child"), 0755)
+	assert.Nil(t, tx.Stage(blocked, []byte("blocked"), DefaultPermissions))
+	assert.NotNil(t, tx.Commit())
+
+	contents, _ = ioutil.ReadFile(existing)
+	assert.Equal(t, "original", string(contents))
+	_, err = os.Stat(filepath.Join(directory, "nested"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Only the files we made ourselves are left
+	leftovers, _ := filepath.Glob(filepath.Join(directory, ".*"))
+	assert.Equal(t, 0, len(leftovers))
+}
+
+func TestCommitConfigFiles(t *testing.T) {
+
+	directory := "transaction"
+	defer os.RemoveAll(directory)
+
+	first := filepath.Join(directory, "first.conf")
+	second := filepath.Join(directory, "second.conf")
+
+	changed, err := CommitConfigFiles(map[string]string{
+		first:  "first",
+		second: "second",
+	}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{first: true, second: true}, changed)
+
+	contents, _ := ioutil.ReadFile(second)
+	assert.Equal(t, "second", string(contents))
+}
+
+func TestSkipUnchangedFiles(t *testing.T) {
+
+	stubFile := "unchanged.conf"
+	defer os.Remove(stubFile)
+	configMap := map[string]string{stubFile: "content"}
+
+	changed, err := MakeConfigFiles(configMap, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{stubFile: true}, changed)
+	assert.Equal(t, STATUS_UNCHANGED, fileStatus(stubFile, "content", DefaultPermissions))
+
+	// A file that is already as it should be is not replaced
+	before, err := os.Stat(stubFile)
+	assert.Nil(t, err)
+	changed, err = MakeConfigFiles(configMap, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{stubFile: false}, changed)
+	after, err := os.Stat(stubFile)
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(before, after))
+
+	changed, err = CommitConfigFiles(configMap, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{stubFile: false}, changed)
+
+	// A file with the wrong mode is rewritten, even if its content is right
+	assert.Nil(t, os.Chmod(stubFile, 0644))
+	assert.Equal(t, STATUS_UPDATE, fileStatus(stubFile, "content", DefaultPermissions))
+	changed, err = MakeConfigFiles(configMap, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]bool{stubFile: true}, changed)
+
+	info, err := os.Stat(stubFile)
+	assert.Nil(t, err)
+	assert.Equal(t, FILE_MODE, info.Mode().Perm())
+}
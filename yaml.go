@@ -0,0 +1,159 @@
+// yaml.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The line of a YAML syntax error, which is only given in its message
+var yamlErrorPattern = regexp.MustCompile(`^yaml: line ([0-9]+): (.*)$`)
+
+// yamlReader maps the nodes of a YAML document onto config nodes.
+type yamlReader struct {
+	fileName string
+}
+
+func (reader *yamlReader) errorf(line int, format string, args ...interface{}) error {
+	return syntaxError(reader.fileName, line, format, args...)
+}
+
+func (reader *yamlReader) readNode(node *yaml.Node) (*configNode, error) {
+
+	switch node.Kind {
+	case yaml.AliasNode:
+		return reader.readNode(node.Alias)
+	case yaml.MappingNode:
+		return reader.readMapping(node)
+	case yaml.SequenceNode:
+		list := listNode(node.Line)
+		for _, item := range node.Content {
+			child, err := reader.readNode(item)
+			if err != nil {
+				return nil, err
+			}
+			list.items = append(list.items, child)
+		}
+		return list, nil
+	case yaml.ScalarNode:
+		return reader.readScalar(node)
+	}
+	return nil, reader.errorf(node.Line, "Unexpected YAML node")
+}
+
+// readMapping reads the keys of a mapping, along with those it merges with <<.
+// Keys given in the mapping itself win over merged ones.
+func (reader *yamlReader) readMapping(node *yaml.Node) (*configNode, error) {
+
+	mapping := objectNode(node.Line)
+	merged := []configField{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Kind != yaml.ScalarNode {
+			return nil, reader.errorf(key.Line, "Keys must be strings")
+		}
+
+		if key.ShortTag() == "!!merge" {
+			sources := []*yaml.Node{value}
+			if value.Kind == yaml.SequenceNode {
+				sources = value.Content
+			}
+			for _, source := range sources {
+				child, err := reader.readNode(source)
+				if err != nil {
+					return nil, err
+				}
+				if !child.object {
+					return nil, reader.errorf(key.Line, "Only mappings can be merged with <<")
+				}
+				merged = append(merged, child.fields...)
+			}
+			continue
+		}
+
+		if mapping.field(key.Value) != nil {
+			return nil, reader.errorf(key.Line, "Duplicate key %q", key.Value)
+		}
+		child, err := reader.readNode(value)
+		if err != nil {
+			return nil, err
+		}
+		mapping.set(key.Value, key.Line, child)
+	}
+
+	for _, field := range merged {
+		if mapping.field(field.key) == nil {
+			mapping.set(field.key, field.line, field.value)
+		}
+	}
+	return mapping, nil
+}
+
+func (reader *yamlReader) readScalar(node *yaml.Node) (*configNode, error) {
+
+	switch node.ShortTag() {
+	case "!!null":
+		return scalarNode(node.Line, nil), nil
+	case "!!bool":
+		var value bool
+		if err := node.Decode(&value); err != nil {
+			return nil, reader.errorf(node.Line, "%s", err)
+		}
+		return scalarNode(node.Line, value), nil
+	case "!!int", "!!float":
+		var number int64
+		if node.ShortTag() == "!!int" && node.Decode(&number) == nil {
+			return scalarNode(node.Line, json.Number(strconv.FormatInt(number, 10))), nil
+		}
+		var value float64
+		if err := node.Decode(&value); err != nil {
+			return nil, reader.errorf(node.Line, "%s", err)
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return nil, reader.errorf(node.Line, "%s cannot be used in a config", node.Value)
+		}
+		return scalarNode(node.Line, json.Number(strconv.FormatFloat(value, 'g', -1, 64))), nil
+	case "!!str", "!!timestamp", "!!binary":
+		return scalarNode(node.Line, node.Value), nil
+	}
+	return nil, reader.errorf(node.Line, "Unsupported YAML tag %s", node.Tag)
+}
+
+// yamlError points at the line of an error from the YAML library.
+func yamlError(fileName string, err error) error {
+
+	if match := yamlErrorPattern.FindStringSubmatch(err.Error()); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return syntaxError(fileName, line, "%s", match[2])
+	}
+	return &ConfigError{File: fileName, Err: fmt.Errorf("%s", strings.TrimPrefix(err.Error(), "yaml: "))}
+}
+
+// parseYAML reads a YAML file, which holds a single document, into nodes.
+func parseYAML(fileName string, src string) (*configNode, error) {
+
+	decoder := yaml.NewDecoder(strings.NewReader(src))
+	var document yaml.Node
+	if err := decoder.Decode(&document); err == io.EOF || err == nil && len(document.Content) == 0 {
+		return objectNode(1), nil
+	} else if err != nil {
+		return nil, yamlError(fileName, err)
+	}
+
+	var next yaml.Node
+	if err := decoder.Decode(&next); err != io.EOF {
+		if err != nil {
+			return nil, yamlError(fileName, err)
+		}
+		return nil, syntaxError(fileName, next.Line, "Only a single YAML document is supported")
+	}
+
+	reader := &yamlReader{fileName: fileName}
+	return reader.readNode(document.Content[0])
+}
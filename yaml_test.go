@@ -0,0 +1,120 @@
+// yaml_test.go
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+
+	node, err := parseYAML("govern.yaml", `---
+# A comment
+name: web   # and another
+port: 8080
+ratio: 0.5
+mode: "0644"
+octal: 0644
+enabled: true
+missing: ~
+empty:
+quoted: "a # b\tc"
+single: 'it''s'
+"key: quoted": yes
+flow: [a, "b, c", {d: 1}]
+spread: [
+  one,
+  two
+]
+defaults: &defaults
+  user: governor
+  group: governor
+merged:
+  <<: *defaults
+  group: web
+alias: *defaults
+nested:
+  list:
+  - one
+  - two
+  items:
+    - name: first
+      value: 1
+    - - inner
+script: |
+  #!/bin/sh
+  echo hi
+
+folded: >-
+  one
+  two
+
+  three
+`)
+	assert.Nil(t, err)
+
+	expected := map[string]interface{}{
+		"name":        "web",
+		"port":        json.Number("8080"),
+		"ratio":       json.Number("0.5"),
+		"mode":        "0644",
+		"octal":       json.Number("420"),
+		"enabled":     true,
+		"missing":     nil,
+		"empty":       nil,
+		"quoted":      "a # b\tc",
+		"single":      "it's",
+		"key: quoted": "yes",
+		"flow": []interface{}{"a", "b, c", map[string]interface{}{
+			"d": json.Number("1"),
+		}},
+		"spread":   []interface{}{"one", "two"},
+		"defaults": map[string]interface{}{"user": "governor", "group": "governor"},
+		"merged":   map[string]interface{}{"user": "governor", "group": "web"},
+		"alias":    map[string]interface{}{"user": "governor", "group": "governor"},
+		"nested": map[string]interface{}{
+			"list": []interface{}{"one", "two"},
+			"items": []interface{}{
+				map[string]interface{}{"name": "first", "value": json.Number("1")},
+				[]interface{}{"inner"},
+			},
+		},
+		"script": "#!/bin/sh\necho hi\n",
+		"folded": "one two\nthree",
+	}
+	assert.Equal(t, expected, nodeJSON(t, node))
+}
+
+func TestParseYAMLLines(t *testing.T) {
+
+	node, err := parseYAML("govern.yaml", "a: 1\n\nb:\n  - c: 2\n    d: 3\n")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, node.line)
+	assert.Equal(t, 3, node.fields[1].line)
+	assert.Equal(t, 5, node.field("b").items[0].fields[1].line)
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+
+	cases := []struct {
+		contents string
+		message  string
+	}{
+		{"a: 1\na: 2\n", "govern.yaml:2: Duplicate key \"a\""},
+		{"a:\n\tb: 1\n", "govern.yaml:2: found character that cannot start any token"},
+		{"a: !custom 1\n", "govern.yaml:1: Unsupported YAML tag !custom"},
+		{"a: .nan\n", "govern.yaml:1: .nan cannot be used in a config"},
+		{"a: [1, 2\n", "govern.yaml:1: did not find expected ',' or ']'"},
+		{"a: 1\n---\nb: 2\n", "govern.yaml:2: Only a single YAML document is supported"},
+		{"a: *nothing\n", "govern.yaml: unknown anchor 'nothing' referenced"},
+		{"a: 1\nb\n", "govern.yaml:2: could not find expected ':'"},
+	}
+
+	for _, c := range cases {
+		_, err := parseYAML("govern.yaml", c.contents)
+		if assert.NotNil(t, err, c.contents) {
+			assert.Contains(t, err.Error(), c.message)
+		}
+	}
+}